@@ -0,0 +1,83 @@
+/*
+Package audit records who ran which command, when, where and how it went, for
+compliance. Each command is an Entry and entries are appended to a Log, which
+can be queried for the most recent ones by user or plugin.
+
+There are two Logs. NewMemory keeps the most recent entries in memory, and
+Open appends them to a file as JSON lines, rotating it when it gets too big
+
+ log, err := audit.Open("/var/log/deckard/audit.log", 10<<20, 5)
+ err = log.Record(audit.Entry{Time: time.Now(), UserID: "U024BE7LH", Plugin: "Dice", Command: "!dice 2d6", Outcome: audit.Replied})
+ entries, err := log.Query(audit.Query{Plugin: "dice", Limit: 10})
+*/
+package audit
+
+import (
+	"strings"
+	"time"
+)
+
+// Outcome is how a command turned out
+type Outcome string
+
+const (
+	// Replied means the plugin finished handling the command
+	Replied Outcome = "replied"
+
+	// Errored means the plugin panicked while handling the command
+	Errored Outcome = "errored"
+
+	// TimedOut means the plugin took longer than the bot's PluginTimeout
+	TimedOut Outcome = "timed_out"
+
+	// Denied means the command wasn't run, because the user didn't have the
+	// role it needs or was rate limited
+	Denied Outcome = "denied"
+)
+
+// Entry is a command someone ran
+type Entry struct {
+	Time       time.Time     `json:"time"`
+	Connection string        `json:"connection,omitempty"`
+	UserID     string        `json:"user_id"`
+	UserName   string        `json:"user_name,omitempty"`
+	Channel    string        `json:"channel,omitempty"`
+	Plugin     string        `json:"plugin"`
+	Command    string        `json:"command"`
+	Outcome    Outcome       `json:"outcome"`
+	Latency    time.Duration `json:"latency_ns"`
+}
+
+// Query picks entries out of a Log
+type Query struct {
+	// User matches the ID or name of the user who ran the command, without
+	// regard to case. Empty matches everyone.
+	User string
+
+	// Plugin matches the name of the plugin, without regard to case.
+	// Empty matches every plugin.
+	Plugin string
+
+	// Limit is the most entries to return. Zero or less means all of them.
+	Limit int
+}
+
+// Matches reports whether the entry is one the query is looking for
+func (q Query) Matches(e Entry) bool {
+	if q.User != "" && !strings.EqualFold(q.User, e.UserID) && !strings.EqualFold(q.User, e.UserName) {
+		return false
+	}
+	return q.Plugin == "" || strings.EqualFold(q.Plugin, e.Plugin)
+}
+
+// Log is an append-only record of commands. It's safe for concurrent use.
+type Log interface {
+	// Record appends the entry to the log
+	Record(Entry) error
+
+	// Query returns the most recent entries that match, newest first
+	Query(Query) ([]Entry, error)
+
+	// Close releases the log's resources
+	Close() error
+}
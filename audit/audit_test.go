@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ExampleMemory() {
+	log := NewMemory(100)
+	log.Record(Entry{UserID: "U1", UserName: "alice", Plugin: "Dice", Command: "!dice 2d6", Outcome: Replied})
+	log.Record(Entry{UserID: "U2", UserName: "bob", Plugin: "Deploy", Command: "!deploy start", Outcome: Denied})
+	log.Record(Entry{UserID: "U1", UserName: "alice", Plugin: "Deploy", Command: "!deploy start", Outcome: TimedOut})
+
+	entries, _ := log.Query(Query{User: "Alice"})
+	for _, e := range entries {
+		fmt.Println(e.UserName, e.Command, e.Outcome)
+	}
+	// Output:
+	// alice !deploy start timed_out
+	// alice !dice 2d6 replied
+}
+
+func TestLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	// room for about three entries per file
+	file, err := Open(path, 500, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for name, log := range map[string]Log{"memory": NewMemory(8), "file": file} {
+		start := time.Date(2016, 10, 1, 9, 0, 0, 0, time.UTC)
+		for i := 0; i < 10; i++ {
+			plugin := "Dice"
+			if i%2 == 1 {
+				plugin = "Cats"
+			}
+			err := log.Record(Entry{
+				Time:    start.Add(time.Duration(i) * time.Minute),
+				UserID:  "U1",
+				Plugin:  plugin,
+				Command: fmt.Sprintf("!command %d", i),
+				Outcome: Replied,
+				Latency: 20 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		}
+
+		entries, err := log.Query(Query{Plugin: "cats", Limit: 3})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(entries) != 3 || entries[0].Command != "!command 9" || entries[2].Command != "!command 5" {
+			t.Errorf("%s: got %+v", name, entries)
+		}
+		if entries[0].Latency != 20*time.Millisecond || !entries[0].Time.Equal(start.Add(9*time.Minute)) {
+			t.Errorf("%s: entry wasn't kept exactly: %+v", name, entries[0])
+		}
+
+		// Old entries are dropped
+		entries, _ = log.Query(Query{User: "u1"})
+		if len(entries) == 0 || len(entries) == 10 || entries[0].Command != "!command 9" {
+			t.Errorf("%s: got %d entries starting %+v", name, len(entries), entries)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		if info, err := os.Stat(p); err != nil || info.Size() > 500 {
+			t.Errorf("%s: %v %v", p, info, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept too many backups: %v", err)
+	}
+}
+
+func TestLongEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := Open(filepath.Join(dir, "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// longer than the chunks the file is read in
+	long := "!write " + strings.Repeat("a", 3*readChunk)
+	for _, command := range []string{"!first", long, "!last"} {
+		if err := file.Record(Entry{UserID: "U1", Command: command}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := file.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Command != "!last" || entries[1].Command != long || entries[2].Command != "!first" {
+		t.Errorf("got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a Log that appends entries to a file as JSON lines. When the file
+// would grow past its maximum size it's renamed to path.1, path.1 to path.2
+// and so on, and a new file is started. Only the newest backups are kept.
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the log at path, creating it if it doesn't exist. The file is
+// rotated once it reaches maxSize bytes, and maxBackups old files are kept.
+// If maxSize is zero or less the file is never rotated.
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	l := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current file for appending. l.mu must be held.
+func (l *File) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// backup returns the path of the nth old file
+func (l *File) backup(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotate moves the current file out of the way and starts a new one.
+// l.mu must be held.
+func (l *File) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(l.backup(l.maxBackups))
+	for n := l.maxBackups - 1; n > 0; n-- {
+		os.Rename(l.backup(n), l.backup(n+1))
+	}
+	if l.maxBackups > 0 {
+		if err := os.Rename(l.path, l.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Record appends the entry to the file as a line of JSON
+func (l *File) Record(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Query reads the current file and its backups from the end, and returns
+// the most recent entries that match, newest first. The files are only
+// locked while they're opened, so entries can be recorded while a long
+// query runs. Lines that can't be read are skipped.
+func (l *File) Query(q Query) (found []Entry, err error) {
+	files, err := l.openAll()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(files)
+	for _, f := range files {
+		err := readBackwards(f.File, f.size, func(line []byte) bool {
+			var e Entry
+			if json.Unmarshal(line, &e) == nil && q.Matches(e) {
+				found = append(found, e)
+			}
+			return q.Limit <= 0 || len(found) < q.Limit
+		})
+		if err != nil || (q.Limit > 0 && len(found) == q.Limit) {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// openFile is an open log file and how much of it had been written when
+// it was opened
+type openFile struct {
+	*os.File
+	size int64
+}
+
+// openAll opens the current file and its backups, newest first. Opening
+// them together under l.mu means a rotation can't move entries from one
+// to the next while they're read.
+func (l *File) openAll() (files []openFile, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for n := 0; n <= l.maxBackups; n++ {
+		path := l.path
+		if n > 0 {
+			path = l.backup(n)
+		}
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			closeAll(files)
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			closeAll(files)
+			return nil, err
+		}
+		files = append(files, openFile{f, info.Size()})
+	}
+	return files, nil
+}
+
+func closeAll(files []openFile) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// readChunk is how much of a file readBackwards reads at a time
+const readChunk = 64 << 10
+
+// readBackwards calls fn with each line of the first size bytes of f, last
+// line first, until fn returns false. Lines can be any length.
+func readBackwards(f *os.File, size int64, fn func(line []byte) bool) error {
+	var partial []byte // the start of a line that began in an earlier chunk
+	for pos := size; pos > 0; {
+		n := int64(readChunk)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		block := make([]byte, n, n+int64(len(partial)))
+		if _, err := f.ReadAt(block, pos); err != nil {
+			return err
+		}
+		block = append(block, partial...)
+		for {
+			i := bytes.LastIndexByte(block, '\n')
+			if i < 0 {
+				break
+			}
+			if line := block[i+1:]; len(line) > 0 && !fn(line) {
+				return nil
+			}
+			block = block[:i]
+		}
+		partial = block
+	}
+	if len(partial) > 0 {
+		fn(partial)
+	}
+	return nil
+}
+
+// Close closes the file
+func (l *File) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,46 @@
+package audit
+
+import "sync"
+
+// Memory is a Log that keeps the most recent entries in memory
+type Memory struct {
+	mu      sync.Mutex
+	entries []Entry // oldest first
+	size    int
+}
+
+// NewMemory returns a Log that keeps the last size entries
+func NewMemory(size int) *Memory {
+	return &Memory{size: size}
+}
+
+// Record appends the entry, forgetting the oldest one if the log is full
+func (m *Memory) Record(e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, e)
+	if len(m.entries) > m.size {
+		m.entries = append([]Entry(nil), m.entries[len(m.entries)-m.size:]...)
+	}
+	return nil
+}
+
+// Query returns the most recent entries that match, newest first
+func (m *Memory) Query(q Query) (found []Entry, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(found) == q.Limit {
+			break
+		}
+		if q.Matches(m.entries[i]) {
+			found = append(found, m.entries[i])
+		}
+	}
+	return
+}
+
+// Close does nothing
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,327 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/audit"
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// AdminRole is the role needed to manage plugins and roles. Admins have
+// every other role too.
+const AdminRole = "admin"
+
+// accessNamespace is where roles granted with !access are kept in the brain
+const accessNamespace = "_access"
+
+// groupPrefix marks a member of a role that is one of the Groups
+const groupPrefix = "group:"
+
+// grants are the roles given out with !access, which are kept in the brain
+// so they survive restarts
+type grants struct {
+	store brain.Store
+
+	mu      sync.Mutex
+	members map[string]map[string]bool // by role, then lowercased member
+}
+
+// roleGrants returns the roles granted with !access, loading them from the
+// brain the first time they're needed
+func (d *Deckard) roleGrants() *grants {
+	d.grantsOnce.Do(func() {
+		d.pluginsMu.Lock()
+		store := brain.Namespace(d.brain(), accessNamespace)
+		d.pluginsMu.Unlock()
+
+		g := &grants{store: store, members: make(map[string]map[string]bool)}
+		err := store.Scan("", func(key string, value []byte) error {
+			i := strings.Index(key, "/")
+			if i < 0 {
+				return nil
+			}
+			g.add(key[:i], key[i+1:])
+			return nil
+		})
+		if err != nil {
+			log.Warnf("Could not load granted roles: %s", err.Error())
+		}
+		d.grants = g
+	})
+	return d.grants
+}
+
+// add records the grant in memory. g.mu must be held, or g not yet shared.
+func (g *grants) add(role, member string) {
+	if g.members[role] == nil {
+		g.members[role] = make(map[string]bool)
+	}
+	g.members[role][member] = true
+}
+
+// grant gives the member the role and saves it in the brain
+func (g *grants) grant(role, member string) error {
+	role, member = strings.ToLower(role), strings.ToLower(member)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.store.Set(role+"/"+member, []byte{}, 0); err != nil {
+		return err
+	}
+	g.add(role, member)
+	return nil
+}
+
+// revoke takes the role away from the member, and reports whether they
+// had been granted it
+func (g *grants) revoke(role, member string) (bool, error) {
+	role, member = strings.ToLower(role), strings.ToLower(member)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.members[role][member] {
+		return false, nil
+	}
+	if err := g.store.Delete(role + "/" + member); err != nil {
+		return false, err
+	}
+	delete(g.members[role], member)
+	if len(g.members[role]) == 0 {
+		delete(g.members, role)
+	}
+	return true, nil
+}
+
+// list returns the members granted each role
+func (g *grants) list() map[string][]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	list := make(map[string][]string)
+	for role, members := range g.members {
+		for member := range members {
+			list[role] = append(list[role], member)
+		}
+		sort.Strings(list[role])
+	}
+	return list
+}
+
+// configuredMembers returns the members the role is given in the bot's
+// configuration
+func (d *Deckard) configuredMembers(role string) (members []string) {
+	for r, m := range d.Roles {
+		if strings.EqualFold(r, role) {
+			members = append(members, m...)
+		}
+	}
+	if strings.EqualFold(role, AdminRole) {
+		members = append(members, d.Admins...)
+	}
+	return
+}
+
+// members returns everyone with the role, from the configuration or !access
+func (d *Deckard) members(role string) []string {
+	return append(d.configuredMembers(role), d.roleGrants().list()[strings.ToLower(role)]...)
+}
+
+// hasRole reports whether the user has the role. Admins have every role.
+// Unverified users have none, and until somebody is an admin nobody is.
+func (d *Deckard) hasRole(user message.User, role string) bool {
+	if user.Unverified {
+		return false
+	}
+	for _, member := range d.members(role) {
+		if d.isMember(member, user) {
+			return true
+		}
+	}
+	for _, member := range d.members(AdminRole) {
+		if d.isMember(member, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMember reports whether the member of a role, a user ID or group:name,
+// is or includes the user
+func (d *Deckard) isMember(member string, user message.User) bool {
+	if !strings.HasPrefix(member, groupPrefix) {
+		return isUser(member, user)
+	}
+	group := strings.TrimPrefix(member, groupPrefix)
+	for name, users := range d.Groups {
+		if !strings.EqualFold(name, group) {
+			continue
+		}
+		for _, u := range users {
+			if isUser(u, user) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isUser reports whether the user ID is the user's. Names aren't matched,
+// since users can change them.
+func isUser(id string, user message.User) bool {
+	return user.ID != "" && strings.EqualFold(id, user.ID)
+}
+
+// authorized reports whether the message's sender may use the plugin. If
+// they can't, they're told why and the refusal is logged.
+func (d *Deckard) authorized(p plugins.Plugin, in message.Basic, r plugins.Responder) bool {
+	rp, ok := p.(plugins.RestrictedPlugin)
+	if !ok {
+		return true
+	}
+	return d.requireRole(rp.Role(in), p.Name(), in, r)
+}
+
+// requireRole reports whether the message's sender has the role the plugin
+// needs, which may be empty if none is needed. If they don't, they're told so
+// and the refusal is recorded in the audit log.
+func (d *Deckard) requireRole(role, plugin string, in message.Basic, r plugins.Responder) bool {
+	if role == "" || d.hasRole(in.Sender, role) {
+		return true
+	}
+	command := commandOf(in)
+	log.WithFields(log.Fields{
+		"Plugin":  plugin,
+		"User":    in.Sender.ID,
+		"Channel": in.Channel,
+		"Role":    role,
+	}).Warn("Access denied")
+	d.audit(in, plugin, audit.Denied, time.Now())
+	r.Send(message.Basic{Text: fmt.Sprintf("Sorry, you need the %s role to use `%s`", role, command)})
+	return false
+}
+
+// commandOf returns the command a message starts with, e.g. "!deploy"
+func commandOf(in message.Basic) string {
+	if fields := strings.Fields(in.Text); len(fields) > 0 {
+		return fields[0]
+	}
+	return in.Text
+}
+
+// normalizeMember turns a mention of a user into their ID, e.g. "<@U024BE7LH>"
+// or "<@U024BE7LH|bob>" into "U024BE7LH" and "@bob" into "bob"
+func normalizeMember(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "<"), ">")
+	if i := strings.Index(s, "|"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimPrefix(s, "@")
+}
+
+// memberArg returns the member an !access command names, as the user's ID
+// if the connection says the argument mentions them
+func memberArg(in message.Basic, arg string) string {
+	if user, ok := in.Mentions[arg]; ok {
+		return user.ID
+	}
+	return normalizeMember(arg)
+}
+
+// accessCommands returns the router for the !access admin command
+func (d *Deckard) accessCommands() *plugins.Router {
+	d.accessCmdsOnce.Do(func() {
+		memberAndRole := []plugins.Arg{
+			{Name: "member", Description: "is a user's ID or mention, or group:name"},
+			{Name: "role"},
+		}
+		d.accessCmds = plugins.NewRouter(&plugins.Command{
+			Name: "!access",
+			Role: AdminRole,
+			Subcommands: []*plugins.Command{
+				{
+					Name:        "list",
+					Description: "lists every role and its members",
+					Handler:     d.handleAccessList,
+				},
+				{
+					Name:        "grant",
+					Description: "gives a user or group a role",
+					Args:        memberAndRole,
+					Handler:     d.handleAccessGrant,
+				},
+				{
+					Name:        "revoke",
+					Description: "takes a role given with `!access grant` away again",
+					Args:        memberAndRole,
+					Handler:     d.handleAccessRevoke,
+				},
+			},
+		})
+	})
+	return d.accessCmds
+}
+
+// handleAccessList replies with the members of every role
+func (d *Deckard) handleAccessList(in message.Basic, args plugins.Args) (out message.Basic) {
+	roles := make(map[string][]string)
+	for role, members := range d.Roles {
+		role = strings.ToLower(role)
+		roles[role] = append(roles[role], members...)
+	}
+	roles[AdminRole] = append(roles[AdminRole], d.Admins...)
+	for role, members := range d.roleGrants().list() {
+		for _, member := range members {
+			roles[role] = append(roles[role], member+" (granted)")
+		}
+	}
+
+	var names []string
+	for role, members := range roles {
+		if len(members) > 0 {
+			names = append(names, role)
+		}
+	}
+	if len(names) == 0 {
+		out.Text = "Nobody has a role"
+		return
+	}
+	sort.Strings(names)
+	s := []string{"*Roles:*"}
+	for _, role := range names {
+		s = append(s, "• *"+role+"* "+strings.Join(roles[role], ", "))
+	}
+	out.Text = strings.Join(s, "\n")
+	return
+}
+
+// handleAccessGrant gives a member a role
+func (d *Deckard) handleAccessGrant(in message.Basic, args plugins.Args) (out message.Basic) {
+	member, role := memberArg(in, args.String("member")), args.String("role")
+	if err := d.roleGrants().grant(role, member); err != nil {
+		out.Text = "Sorry, I couldn't save that: " + err.Error()
+		return
+	}
+	log.WithFields(log.Fields{"Member": member, "Role": role, "By": in.Sender.ID}).Info("Role Granted")
+	out.Text = fmt.Sprintf("Gave %s the %s role", member, role)
+	return
+}
+
+// handleAccessRevoke takes a granted role away from a member
+func (d *Deckard) handleAccessRevoke(in message.Basic, args plugins.Args) (out message.Basic) {
+	member, role := memberArg(in, args.String("member")), args.String("role")
+	revoked, err := d.roleGrants().revoke(role, member)
+	switch {
+	case err != nil:
+		out.Text = "Sorry, I couldn't save that: " + err.Error()
+	case revoked:
+		log.WithFields(log.Fields{"Member": member, "Role": role, "By": in.Sender.ID}).Info("Role Revoked")
+		out.Text = fmt.Sprintf("Took the %s role away from %s", role, member)
+	default:
+		out.Text = fmt.Sprintf("Sorry, %s wasn't given the %s role with `!access grant`. Roles in the configuration have to be changed there.", member, role)
+	}
+	return
+}
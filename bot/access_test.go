@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// deployPlugin has commands that need roles
+type deployPlugin struct {
+	testPlugin
+	router *plugins.Router
+}
+
+func newDeployPlugin() *deployPlugin {
+	reply := func(text string) func(message.Basic, plugins.Args) message.Basic {
+		return func(message.Basic, plugins.Args) message.Basic { return message.Basic{Text: text} }
+	}
+	return &deployPlugin{
+		testPlugin: testPlugin{name: "Deploy"},
+		router: plugins.NewRouter(&plugins.Command{
+			Name: "!deploy",
+			Role: "deployer",
+			Subcommands: []*plugins.Command{
+				{Name: "start", Handler: reply("deploying")},
+				{Name: "status", Handler: reply("nothing is deploying")},
+				{Name: "rollback", Role: "admin", Handler: reply("rolling back")},
+			},
+		}),
+	}
+}
+
+func (p *deployPlugin) Regexp() *regexp.Regexp       { return p.router.Regexp() }
+func (p *deployPlugin) Role(in message.Basic) string { return p.router.Role(in) }
+func (p *deployPlugin) HandleMessage(in message.Basic) message.Basic {
+	return p.router.HandleMessage(in)
+}
+
+func TestAccess(t *testing.T) {
+	store := brain.NewMemory()
+	d := &Deckard{
+		Name:          "Test",
+		Plugins:       []plugins.Plugin{newDeployPlugin()},
+		PluginTimeout: time.Second,
+		Brain:         store,
+		Admins:        []string{"UBOSS"},
+		Roles:         map[string][]string{"deployer": {"U1", "group:ops"}},
+		Groups:        map[string][]string{"ops": {"UOPS"}},
+	}
+
+	from := func(id, name, text string) message.Basic {
+		return message.Basic{Text: text, Envelope: message.Envelope{Sender: message.User{ID: id, Name: name}}}
+	}
+	unverified := func(in message.Basic) message.Basic {
+		in.Sender.Unverified = true
+		return in
+	}
+	mentioning := func(in message.Basic, mention string, user message.User) message.Basic {
+		in.Mentions = map[string]message.User{mention: user}
+		return in
+	}
+	tests := []struct {
+		in   message.Basic
+		want string
+	}{
+		{from("U1", "Alice", "!deploy start"), "deploying"},
+		{from("UOPS", "", "!deploy start"), "deploying"},
+		{from("UBOSS", "", "!deploy start"), "deploying"},
+		{unverified(from("UBOSS", "", "!deploy start")), "Sorry, you need the deployer role to use `!deploy`"},
+		{from("U2", "bob", "!deploy start"), "Sorry, you need the deployer role to use `!deploy`"},
+		{from("U4", "U1", "!deploy start"), "Sorry, you need the deployer role to use `!deploy`"},
+		{from("U1", "Alice", "!deploy rollback"), "Sorry, you need the admin role to use `!deploy`"},
+		{from("U2", "bob", "!plugin list"), "Sorry, you need the admin role to use `!plugin`"},
+		{from("U2", "bob", "!access grant bob deployer"), "Sorry, you need the admin role to use `!access`"},
+		{from("UBOSS", "", "!access grant <@U2> deployer"), "Gave U2 the deployer role"},
+		{from("U2", "bob", "!deploy start"), "deploying"},
+		{from("UBOSS", "", "!access revoke U1 deployer"), "Sorry, U1 wasn't given the deployer role with `!access grant`. Roles in the configuration have to be changed there."},
+		{mentioning(from("UBOSS", "", "!access grant @carol deployer"), "@carol", message.User{ID: "U3", Name: "Carol"}), "Gave U3 the deployer role"},
+		{from("UBOSS", "", "!access list"), "*Roles:*\n• *admin* UBOSS\n• *deployer* U1, group:ops, u2 (granted), u3 (granted)"},
+	}
+	for i, tt := range tests {
+		tt.in.ID = i
+		tx := make(message.BasicChannel)
+		go d.handleMessage(tt.in, tx)
+		got := strings.Join(collect(t, tx), "|")
+		if got != tt.want {
+			t.Errorf("%s: %q got %q, want %q", tt.in.Sender.ID, tt.in.Text, got, tt.want)
+		}
+	}
+
+	// Grants are kept in the brain for the next bot
+	restarted := &Deckard{Brain: store, Admins: []string{"UBOSS"}}
+	if !restarted.hasRole(message.User{ID: "U2"}, "deployer") {
+		t.Error("restarted bot forgot the granted role")
+	}
+	if restarted.hasRole(message.User{ID: "U4", Name: "U2"}, "deployer") {
+		t.Error("restarted bot gave a role to someone without it")
+	}
+
+	// Until somebody is an admin, nobody is
+	closed := &Deckard{}
+	if closed.hasRole(message.User{ID: "U2"}, AdminRole) || closed.hasRole(message.User{ID: "U2"}, "deployer") {
+		t.Error("bot without admins got roles wrong")
+	}
+}
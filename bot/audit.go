@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/audit"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// auditMemorySize is how many commands are kept when the bot isn't given
+// an audit log
+const auditMemorySize = 1000
+
+// auditLog returns the bot's audit log, keeping it in memory if it
+// wasn't given one
+func (d *Deckard) auditLog() audit.Log {
+	d.auditOnce.Do(func() {
+		if d.Audit == nil {
+			d.Audit = audit.NewMemory(auditMemorySize)
+		}
+	})
+	return d.Audit
+}
+
+// audit records that the message's sender ran a command of the plugin,
+// which started at start and turned out as outcome
+func (d *Deckard) audit(in message.Basic, plugin string, outcome audit.Outcome, start time.Time) {
+	err := d.auditLog().Record(audit.Entry{
+		Time:       start,
+		Connection: in.Connection,
+		UserID:     in.Sender.ID,
+		UserName:   in.Sender.Name,
+		Channel:    in.Channel,
+		Plugin:     plugin,
+		Command:    in.Text,
+		Outcome:    outcome,
+		Latency:    time.Since(start),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"Plugin": plugin, "Error": err.Error()}).Warn("Could not record command in the audit log")
+	}
+}
+
+// closeAudit closes the audit log once nothing else will be recorded
+func (d *Deckard) closeAudit() {
+	if err := d.auditLog().Close(); err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not close the audit log")
+	}
+}
+
+// auditCommands returns the router for the !audit admin command
+func (d *Deckard) auditCommands() *plugins.Router {
+	d.auditCmdsOnce.Do(func() {
+		d.auditCmds = plugins.NewRouter(&plugins.Command{
+			Name:        "!audit",
+			Description: "lists the most recent commands, newest first",
+			Role:        AdminRole,
+			Flags: []plugins.Arg{
+				{Name: "user", Description: "only lists commands run by this user's ID, name or mention"},
+				{Name: "plugin", Description: "only lists this plugin's commands"},
+				{Name: "limit", Type: plugins.IntArg, Default: "10", Description: fmt.Sprintf("is how many to list, at most %d", maxAuditLimit)},
+			},
+			Handler: d.handleAudit,
+		})
+	})
+	return d.auditCmds
+}
+
+// maxAuditLimit is the most commands !audit lists, so that a reply never
+// holds the whole log
+const maxAuditLimit = 100
+
+// handleAudit replies with the most recent commands that match
+func (d *Deckard) handleAudit(in message.Basic, args plugins.Args) (out message.Basic) {
+	limit := args.Int("limit")
+	if limit <= 0 || limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	entries, err := d.auditLog().Query(audit.Query{
+		User:   normalizeMember(args.String("user")),
+		Plugin: args.String("plugin"),
+		Limit:  limit,
+	})
+	if err != nil {
+		out.Text = "Sorry, I couldn't read the audit log: " + err.Error()
+		return
+	}
+	if len(entries) == 0 {
+		out.Text = "No commands found"
+		return
+	}
+	s := []string{"*Recent commands:*"}
+	for _, e := range entries {
+		user := e.UserID
+		if e.UserName != "" {
+			user = e.UserName
+		}
+		s = append(s, fmt.Sprintf("• %s *%s* `%s` %s %s in %dms", e.Time.Format(time.RFC1123), user, e.Command, e.Plugin, e.Outcome, e.Latency/time.Millisecond))
+	}
+	out.Text = strings.Join(s, "\n")
+	return
+}
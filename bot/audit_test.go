@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/audit"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+func TestAudit(t *testing.T) {
+	log := audit.NewMemory(100)
+	d := &Deckard{
+		Plugins: []plugins.Plugin{
+			&testPlugin{name: "Fast", reply: "fast reply"},
+			&testPlugin{name: "Slow", reply: "slow reply", delay: time.Second},
+			&panicPlugin{testPlugin{name: "Panic"}},
+			newDeployPlugin(),
+		},
+		PluginTimeout: 50 * time.Millisecond,
+		Admins:        []string{"UBOSS"},
+		Audit:         log,
+	}
+	send := func(id, text string) []string {
+		tx := make(message.BasicChannel)
+		go d.handleMessage(message.Basic{Text: text, Envelope: message.Envelope{
+			Sender:     message.User{ID: id},
+			Channel:    "C1",
+			Connection: "slack",
+		}}, tx)
+		return collect(t, tx)
+	}
+	send("U1", "!test")
+	send("U1", "!deploy start")
+	send("UBOSS", "!plugin list")
+	send("U2", "!help")
+	send("U2", "!who")
+
+	entries, err := log.Query(audit.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		if e.Connection != "slack" || e.Channel != "C1" || e.Time.IsZero() {
+			t.Errorf("entry is missing where the command was run: %+v", e)
+		}
+		got = append(got, e.UserID+" "+e.Plugin+" "+e.Command+" "+string(e.Outcome))
+	}
+	sort.Strings(got)
+	want := []string{
+		"U1 Deploy !deploy start denied",
+		"U1 Fast !test replied",
+		"U1 Panic !test errored",
+		"U1 Slow !test timed_out",
+		"U2 Deckard !help replied",
+		"U2 Deckard !who replied",
+		"UBOSS Deckard !plugin list replied",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got entries\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	reply := send("UBOSS", "!audit --plugin deploy")
+	if len(reply) != 1 || !strings.Contains(reply[0], "`!deploy start` Deploy denied") || strings.Contains(reply[0], "!test") {
+		t.Errorf("got !audit reply %q", reply)
+	}
+	if reply := send("U1", "!audit"); len(reply) != 1 || reply[0] != "Sorry, you need the admin role to use `!audit`" {
+		t.Errorf("got !audit reply %q for a user who isn't an admin", reply)
+	}
+
+	// No limit still doesn't list the whole log
+	for i := 0; i < maxAuditLimit; i++ {
+		log.Record(audit.Entry{UserID: "U1", Plugin: "Fast", Command: "!test"})
+	}
+	if reply := send("UBOSS", "!audit --limit 0"); len(reply) != 1 || strings.Count(reply[0], "\n") != maxAuditLimit {
+		t.Errorf("got %d lines from !audit --limit 0", strings.Count(strings.Join(reply, "\n"), "\n")+1)
+	}
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/plugins"
+	"github.com/handwritingio/deckard-bot/ratelimit"
+)
+
+// FromConfig creates a bot from a configuration file, with its connections,
+// brain, audit log and every enabled plugin. Connections and plugins are
+// created by the factories they registered with connection.Register and
+// plugins.Register, so their packages must be imported. Errors name the key
+// in the file that's wrong.
+func FromConfig(f *config.File) (*Deckard, error) {
+	d, err := fromSettings(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range f.Connections {
+		conn, err := connection.New(c.Type, c.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("connections[%d]: %s", i, err.Error())
+		}
+		if err := d.AddConnection(c.Name, conn); err != nil {
+			return nil, fmt.Errorf("connections[%d].name: %s", i, err.Error())
+		}
+	}
+
+	// Build every plugin before opening anything, so a bad section doesn't
+	// leave the brain open. They're added in order of name so that the
+	// same file always gives the same bot.
+	var names []string
+	for name, settings := range f.Plugins {
+		if settings.Enabled() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var ps []plugins.Plugin
+	for _, name := range names {
+		p, err := plugins.New(name, f.Plugins[name])
+		if err != nil {
+			return nil, fmt.Errorf("plugins.%s: %s", name, err.Error())
+		}
+		ps = append(ps, p)
+	}
+
+	if err := d.open(f.BrainPath, f.Audit.Path, f.Audit.MaxSizeMB, f.Audit.MaxBackups); err != nil {
+		return nil, err
+	}
+	for i, p := range ps {
+		d.addPlugin(p, names[i])
+	}
+
+	log.Infof("Bot named %s Created", d.Name)
+	return d, nil
+}
+
+// fromSettings creates a bot with the configuration's bot-wide settings, but
+// none of its connections or plugins, and without opening its brain or
+// audit log
+func fromSettings(f *config.File) (*Deckard, error) {
+	limits, err := rateLimitsFromFile(f.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+	return &Deckard{
+		Name:             f.Name,
+		Plugins:          make([]plugins.Plugin, 0),
+		PluginTimeout:    f.PluginTimeout,
+		ShutdownTimeout:  f.ShutdownTimeout,
+		Admins:           f.Admins,
+		Roles:            f.Roles,
+		Groups:           f.Groups,
+		PanicLimit:       f.PanicLimit,
+		PanicWindow:      f.PanicWindow,
+		RateLimits:       limits,
+		MetricsAddr:      f.MetricsAddr,
+		HealthTimeout:    f.HealthTimeout,
+		pluginInitResult: make(chan pluginResult),
+	}, nil
+}
+
+// rateLimitsFromFile parses the rate limits in the configuration file
+func rateLimitsFromFile(f config.RateLimitsFile) (limits RateLimits, err error) {
+	if limits.User, err = ratelimit.ParseLimit(f.User); err != nil {
+		return limits, fmt.Errorf("rate_limits.user: %s", err.Error())
+	}
+	if limits.Channel, err = ratelimit.ParseLimit(f.Channel); err != nil {
+		return limits, fmt.Errorf("rate_limits.channel: %s", err.Error())
+	}
+	limits.Plugins = make(map[string]ratelimit.Limit)
+	for name, s := range f.Plugins {
+		if limits.Plugins[name], err = ratelimit.ParseLimit(s); err != nil {
+			return limits, fmt.Errorf("rate_limits.plugins.%s: %s", name, err.Error())
+		}
+	}
+	return limits, nil
+}
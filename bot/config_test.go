@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+	"github.com/handwritingio/deckard-bot/ratelimit"
+
+	// Importing a connection's package makes it available to the
+	// configuration file
+	_ "github.com/handwritingio/deckard-bot/connection/slack"
+	_ "github.com/handwritingio/deckard-bot/connection/web"
+)
+
+func init() {
+	plugins.Register("test", func(settings config.Section) (plugins.Plugin, error) {
+		return &testPlugin{name: "Test", reply: settings.String("reply")}, nil
+	})
+}
+
+func TestFromConfig(t *testing.T) {
+	f := config.Defaults()
+	f.Name = "Rachael"
+	f.RateLimits.Plugins = map[string]string{"Test": "5/1h"}
+	f.Connections = []config.ConnectionFile{{Type: "web", Name: "http", Settings: config.Section{"addr": ":0"}}}
+	f.Plugins = map[string]config.Section{
+		"test":     {"reply": "hi"},
+		"disabled": {"enabled": false},
+	}
+	d, err := FromConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "Rachael" || d.PluginTimeout != 30*time.Second {
+		t.Errorf("settings weren't copied: %+v", d)
+	}
+	if d.RateLimits.Plugins["Test"] != (ratelimit.Limit{Burst: 5, Per: time.Hour}) {
+		t.Errorf("rate limits = %+v", d.RateLimits)
+	}
+	if len(d.conns) != 1 || d.conns[0].name != "http" {
+		t.Errorf("connections = %+v", d.conns)
+	}
+	if status := d.PluginStatus(); len(status) != 1 || status[0].Name != "Test" {
+		t.Errorf("plugins = %+v, want only Test", status)
+	}
+	help := message.PlainText(d.pluginHelp(""))
+	if strings.Contains(help, "available but not enabled:* test") {
+		t.Errorf("help lists the enabled test plugin as available:\n%s", help)
+	}
+
+	f.Plugins["missing"] = nil
+	if _, err := FromConfig(f); err == nil || !strings.HasPrefix(err.Error(), "plugins.missing:") {
+		t.Errorf("unknown plugin error = %v", err)
+	}
+	delete(f.Plugins, "missing")
+	for _, test := range []struct {
+		conn config.ConnectionFile
+		err  string
+	}{
+		{config.ConnectionFile{Type: "web", Settings: config.Section{"port": 8080}}, "connections[0]: "},
+		{config.ConnectionFile{Type: "irc"}, `connections[0]: "irc" isn't a kind of connection`},
+		{config.ConnectionFile{Type: "slack"}, "connections[0]: token: "},
+		{config.ConnectionFile{Type: "slack", Settings: config.Section{"token": "xoxb", "mode": "events", "addr": ":3000"}}, "connections[0]: signing_secret: "},
+	} {
+		f.Connections = []config.ConnectionFile{test.conn}
+		if _, err := FromConfig(f); err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%+v: error = %v, want it to start %q", test.conn, err, test.err)
+		}
+	}
+}
+
+func TestHelpListsAvailablePlugins(t *testing.T) {
+	d := &Deckard{}
+	if help := message.PlainText(d.pluginHelp("")); !strings.Contains(help, "*These plugins are available but not enabled:* test") {
+		t.Errorf("help doesn't list the test plugin:\n%s", help)
+	}
+}
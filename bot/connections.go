@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"sync"
+
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// attachedConnection is a connection added to the bot, along with the
+// channels it returned from Start
+type attachedConnection struct {
+	name   string
+	conn   connection.Connection
+	rx, tx message.BasicChannel
+}
+
+// label is what the connection is called in metrics: its name, or the name
+// of its package if it wasn't given one, e.g. "slack"
+func (ac *attachedConnection) label() string {
+	if ac.name != "" {
+		return ac.name
+	}
+	t := reflect.TypeOf(ac.conn)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath())
+}
+
+// connectionError is an error sent by one of the bot's connections
+type connectionError struct {
+	name string
+	err  error
+}
+
+// AddConnection attaches another connection to the bot, so one bot can talk
+// over Slack, stdio and HTTP at the same time. Replies always go back out on
+// the connection the message came in on. The name is set as the Connection
+// on every message's envelope so plugins can tell where a message came from.
+// If the name is empty, the connection's own name for itself is kept.
+// Connections must be added before Run is called.
+func (d *Deckard) AddConnection(name string, conn connection.Connection) error {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	if d.cancel != nil {
+		return fmt.Errorf("can't add connection %s to a running bot", name)
+	}
+	for _, ac := range d.conns {
+		if name != "" && ac.name == name {
+			return fmt.Errorf("there's already a connection named %s", name)
+		}
+	}
+	d.conns = append(d.conns, &attachedConnection{name: name, conn: conn})
+	log.WithFields(log.Fields{"Connection": name}).Info("Connection Added")
+	return nil
+}
+
+// startConnections starts every connection. Errors they send are
+// forwarded to errs, tagged with the connection's name, until ctx is done.
+func (d *Deckard) startConnections(ctx context.Context, errs chan<- connectionError) {
+	for _, ac := range d.conns {
+		// buffered so a connection can report an error before we start listening
+		connErrs := make(chan error, 1)
+		ac.rx, ac.tx = ac.conn.Start(connErrs)
+		go func(ac *attachedConnection) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err := <-connErrs:
+					select {
+					case errs <- connectionError{ac.label(), err}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(ac)
+	}
+}
+
+// runPumps runs a message pump for every connection, and the scheduler,
+// until ctx is done
+func (d *Deckard) runPumps(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.runScheduler(ctx)
+	}()
+	for _, ac := range d.conns {
+		wg.Add(1)
+		go func(ac *attachedConnection) {
+			defer wg.Done()
+			d.messagePump(ctx, ac.name, ac.rx, ac.tx)
+		}(ac)
+	}
+	wg.Wait()
+}
+
+// closeConnections closes every connection and returns the first error
+func (d *Deckard) closeConnections() (err error) {
+	for _, ac := range d.conns {
+		if closeErr := ac.conn.Close(); closeErr != nil {
+			log.WithFields(log.Fields{
+				"Connection": ac.name,
+				"Error":      closeErr.Error(),
+			}).Warn("Could not close connection")
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return
+}
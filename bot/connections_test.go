@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// connectionNamePlugin replies with the connection the message came in on
+type connectionNamePlugin struct {
+	testPlugin
+}
+
+func (p *connectionNamePlugin) HandleMessage(in message.Basic) (out message.Basic) {
+	out.Text = in.Connection
+	return
+}
+
+func TestRunRoutesRepliesToTheirConnection(t *testing.T) {
+	d, err := New("Test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Plugins = []plugins.Plugin{&connectionNamePlugin{testPlugin{name: "Where"}}}
+	terminal, web := newTestConnection(), newTestConnection()
+	if err := d.AddConnection("terminal", terminal); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddConnection("web", web); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddConnection("web", newTestConnection()); err == nil {
+		t.Error("adding a second connection named web didn't fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error)
+	go func() { runErr <- d.Run(ctx) }()
+
+	for _, conn := range []*testConnection{web, terminal} {
+		conn.rx <- message.Basic{ID: 1, Text: "!test"}
+	}
+	for name, conn := range map[string]*testConnection{"terminal": terminal, "web": web} {
+		got := collect(t, conn.tx)
+		if len(got) != 1 || got[0] != name {
+			t.Errorf("%s connection got replies %q, want [%q]", name, got, name)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return")
+	}
+	for _, conn := range []*testConnection{web, terminal} {
+		select {
+		case <-conn.closed:
+		default:
+			t.Error("connection wasn't closed")
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// conversationKey identifies who a conversation is with, and where
+type conversationKey struct {
+	connection, channel, user string
+}
+
+func conversationKeyFor(in message.Basic) conversationKey {
+	return conversationKey{in.Connection, in.Channel, in.Sender.ID}
+}
+
+// claim is a plugin waiting for the next message in a conversation
+type claim struct {
+	id      int
+	plugin  plugins.Plugin
+	in      message.Basic // the message the plugin was handling when it asked
+	role    string        // the role needed to answer
+	handle  plugins.ReplyHandler
+	expired func()
+	expires time.Time
+
+	// answered is set while the plugin handles the answer, so a new
+	// claim can carry on the conversation with the same role
+	answered bool
+
+	// done is closed when the claim is removed
+	done chan struct{}
+}
+
+// conversations holds the claims plugins have made on people's next messages
+type conversations struct {
+	mu     sync.Mutex
+	claims map[conversationKey]*claim
+	nextID int
+}
+
+// remove forgets the claim on key if it's still cl, and reports whether it
+// was. c.mu must be held.
+func (c *conversations) remove(key conversationKey, cl *claim) bool {
+	if current, ok := c.claims[key]; !ok || current.id != cl.id {
+		return false
+	}
+	delete(c.claims, key)
+	close(cl.done)
+	return true
+}
+
+// pluginConversations is the plugins.Conversations given to a plugin
+type pluginConversations struct {
+	d      *Deckard
+	plugin plugins.Plugin
+}
+
+func (pc *pluginConversations) Await(in message.Basic, timeout time.Duration, handle plugins.ReplyHandler) (cancel func()) {
+	return pc.d.await(pc.plugin, in, timeout, handle, nil)
+}
+
+func (pc *pluginConversations) AwaitOrExpire(in message.Basic, timeout time.Duration, handle plugins.ReplyHandler, expired func()) (cancel func()) {
+	return pc.d.await(pc.plugin, in, timeout, handle, expired)
+}
+
+// await claims the next message from in's sender in in's channel for the
+// plugin. The answer needs the role that in did, or that the answer the
+// plugin is handling did.
+func (d *Deckard) await(p plugins.Plugin, in message.Basic, timeout time.Duration, handle plugins.ReplyHandler, expired func()) (cancel func()) {
+	key := conversationKeyFor(in)
+	var role string
+	if rp, ok := p.(plugins.RestrictedPlugin); ok {
+		role = rp.Role(in)
+	}
+
+	c := &d.convos
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claims == nil {
+		c.claims = make(map[conversationKey]*claim)
+	}
+	if old, ok := c.claims[key]; ok {
+		if old.answered && old.plugin == p && role == "" {
+			role = old.role
+		}
+		c.remove(key, old)
+	}
+	c.nextID++
+	cl := &claim{
+		id:      c.nextID,
+		plugin:  p,
+		in:      in,
+		role:    role,
+		handle:  handle,
+		expired: expired,
+		expires: d.clock().Now().Add(timeout),
+		done:    make(chan struct{}),
+	}
+	c.claims[key] = cl
+	go d.expireClaim(key, cl, d.clock().After(timeout))
+
+	log.WithFields(log.Fields{
+		"Plugin":  p.Name(),
+		"User":    in.Sender.ID,
+		"Channel": in.Channel,
+		"Timeout": timeout.String(),
+	}).Debug("Waiting for reply")
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.remove(key, cl)
+	}
+}
+
+// expireClaim forgets the claim once its timeout passes, if nobody has
+// answered it, and tells the plugin
+func (d *Deckard) expireClaim(key conversationKey, cl *claim, timeout <-chan time.Time) {
+	select {
+	case <-timeout:
+	case <-cl.done:
+		return
+	case <-d.quitting():
+		return
+	}
+
+	c := &d.convos
+	c.mu.Lock()
+	expired := !cl.answered && c.remove(key, cl)
+	c.mu.Unlock()
+	if !expired {
+		return
+	}
+	log.WithFields(log.Fields{
+		"Plugin":  cl.plugin.Name(),
+		"User":    cl.in.Sender.ID,
+		"Channel": cl.in.Channel,
+	}).Debug("Nobody replied in time")
+	if cl.expired != nil {
+		d.safeExpire(cl)
+	}
+}
+
+// claimFor returns the claim on the message, if a plugin that's still
+// registered is waiting for it. The claim is marked answered, and must be
+// finished with finishClaim once the plugin has handled the message.
+func (d *Deckard) claimFor(in message.Basic) *claim {
+	key := conversationKeyFor(in)
+	c := &d.convos
+	c.mu.Lock()
+	cl, ok := c.claims[key]
+	if !ok || cl.answered || !d.clock().Now().Before(cl.expires) {
+		// expired claims are left for expireClaim to tell the plugin about
+		c.mu.Unlock()
+		return nil
+	}
+	cl.answered = true
+	c.mu.Unlock()
+
+	if d.registeredPlugin(cl.plugin.Name()) == nil {
+		// disabled since it asked
+		d.finishClaim(in, cl)
+		return nil
+	}
+	return cl
+}
+
+// finishClaim forgets the answered claim, unless the plugin has already
+// replaced it with another
+func (d *Deckard) finishClaim(in message.Basic, cl *claim) {
+	c := &d.convos
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(conversationKeyFor(in), cl)
+}
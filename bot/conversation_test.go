@@ -0,0 +1,129 @@
+package bot
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// confirmPlugin asks whether you're sure before it does anything
+type confirmPlugin struct {
+	testPlugin
+	convos   plugins.Conversations
+	timeouts chan string
+}
+
+func (p *confirmPlugin) SetConversations(c plugins.Conversations) { p.convos = c }
+
+func (p *confirmPlugin) HandleMessageStream(in message.Basic, r plugins.Responder) {
+	r.Send(message.Basic{Text: "Are you sure?"})
+	p.convos.AwaitOrExpire(in, time.Minute, p.confirm, func() { p.timeouts <- in.Sender.ID })
+}
+
+func (p *confirmPlugin) confirm(next message.Basic, r plugins.Responder) {
+	switch next.Text {
+	case "yes":
+		r.Send(message.Basic{Text: "Done"})
+	case "no":
+		r.Send(message.Basic{Text: "Cancelled"})
+	default:
+		r.Send(message.Basic{Text: "Yes or no?"})
+		p.convos.Await(next, time.Minute, p.confirm)
+	}
+}
+
+func TestConversations(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2016, 9, 30, 8, 0, 0, 0, time.Local)}
+	p := &confirmPlugin{testPlugin: testPlugin{name: "Confirm"}, timeouts: make(chan string, 1)}
+	d := &Deckard{
+		Name:          "Test",
+		Plugins:       []plugins.Plugin{p, &echoPlugin{testPlugin{name: "Echo"}}},
+		PluginTimeout: time.Second,
+		Clock:         clock,
+	}
+	p.SetConversations(&pluginConversations{d: d, plugin: p})
+
+	from := func(user, channel, text string) message.Basic {
+		return message.Basic{Text: text, Envelope: message.Envelope{
+			Sender:  message.User{ID: user},
+			Channel: channel,
+		}}
+	}
+	// replies from more than one plugin are compared in sorted order
+	tests := []struct {
+		in      message.Basic
+		advance time.Duration
+		want    []string
+	}{
+		{from("U1", "C1", "!test"), 0, []string{"Are you sure?", "!test"}},
+		// someone else, or the same person somewhere else, isn't the answer
+		{from("U2", "C1", "!test yes"), 0, []string{"Are you sure?", "!test yes"}},
+		{from("U1", "C2", "yes"), 0, nil},
+		{from("U1", "C1", "maybe"), 0, []string{"Yes or no?"}},
+		{from("U1", "C1", "yes"), 0, []string{"Done"}},
+		// the conversation is over
+		{from("U1", "C1", "yes"), 0, nil},
+		{from("U2", "C1", "no"), 0, []string{"Cancelled"}},
+		// nobody answered in time
+		{from("U1", "C1", "!test"), 0, []string{"Are you sure?", "!test"}},
+		{from("U1", "C1", "yes"), 2 * time.Minute, nil},
+	}
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		tt.in.ID = i
+		tx := make(message.BasicChannel)
+		go d.handleMessage(tt.in, tx)
+		got := collect(t, tx)
+		sort.Strings(got)
+		sort.Strings(tt.want)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%d: %q got replies %q, want %q", i, tt.in.Text, got, tt.want)
+		}
+	}
+	select {
+	case user := <-p.timeouts:
+		if user != "U1" {
+			t.Errorf("timed out talking to %s, want U1", user)
+		}
+	case <-time.After(time.Second):
+		t.Error("plugin wasn't told nobody answered")
+	}
+}
+
+// deployConfirmPlugin is a confirmPlugin that needs the deployer role
+type deployConfirmPlugin struct {
+	confirmPlugin
+}
+
+func (p *deployConfirmPlugin) Role(message.Basic) string { return "deployer" }
+
+func TestConversationAnswersAreChecked(t *testing.T) {
+	p := &deployConfirmPlugin{confirmPlugin{testPlugin: testPlugin{name: "Confirm"}, timeouts: make(chan string, 1)}}
+	d := &Deckard{
+		Name:          "Test",
+		Plugins:       []plugins.Plugin{p},
+		PluginTimeout: time.Second,
+		Roles:         map[string][]string{"deployer": {"U1"}},
+	}
+	p.SetConversations(&pluginConversations{d: d, plugin: p})
+	send := func(text string) string {
+		in := message.Basic{Text: text, Envelope: message.Envelope{Sender: message.User{ID: "U1"}, Channel: "C1"}}
+		tx := make(message.BasicChannel)
+		go d.handleMessage(in, tx)
+		return strings.Join(collect(t, tx), "|")
+	}
+
+	send("!test")
+	if got := send("maybe"); got != "Yes or no?" {
+		t.Errorf("answer got %q", got)
+	}
+	// the role is taken away before they answer again
+	d.Roles = nil
+	if got := send("yes"); got != "Sorry, you need the deployer role to use `yes`" {
+		t.Errorf("answer without the role got %q", got)
+	}
+}
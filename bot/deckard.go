@@ -2,7 +2,9 @@
 Package bot manages the creation of the chatbot and adding plugins
 to the chatbot. The bot is created and configured with a connection.
 The connection is the method in which the chatbot interfaces with humans
-Examples are stdin/stdout, Slack, Hipchat, etc.
+Examples are stdin/stdout, Slack, Hipchat, etc. More connections can be
+attached with AddConnection, and each reply goes back out on the connection
+its message came in on.
 
 It configures the plugin list and starts the bot with the
 message pump so messages can be sent and received through all
@@ -16,6 +18,37 @@ through the message pump to each plugin. Only the text of the message is sent
 through the message pump. Any additional values send into the message pump are
 held and returned with the response.
 
+Each incoming message is handled on its own goroutine and every matching plugin
+is called concurrently, so a slow plugin never holds up anyone else. A plugin
+that takes longer than PluginTimeout is abandoned and the user is told it timed
+out. The final Finished message is only sent once every matching plugin has
+answered or timed out. A plugin that panics is recovered, reported to Sentry
+and the user gets an apology; one that panics PanicLimit times within
+PanicWindow is quarantined until an admin enables it again.
+
+Middleware added with Use wraps the handling of every message, and can
+inspect, rewrite, drop or answer incoming messages and outgoing replies before
+the plugins see them. Deckard's own commands (!help, !who, !plugin and
+!access) are answered by a built-in middleware that runs last.
+
+Users are given roles in Roles, or at runtime with !access, and a plugin that
+implements RestrictedPlugin is only sent a message if its sender has the role
+it needs. Everyone else gets a polite refusal. Every command, and how it
+turned out, is recorded in the Audit log, which admins can search with !audit.
+
+Plugins can also post on their own with the scheduler, on a cron schedule,
+at a regular interval or once at a set time. Jobs are kept in the brain so
+they survive restarts, and are posted through the first connection that can
+post messages unless the job names another.
+
+Lifecycle
+
+Run starts the bot and blocks until its context is cancelled, Stop is called or
+a connection fails. On the way out the bot stops reading new messages, gives
+in-flight plugin calls up to ShutdownTimeout to send their replies, shuts the
+plugins down and then closes the brain, the audit log and the connections. Go
+is a convenience wrapper around Run that stops the bot on SIGINT or SIGTERM.
+
 Plugins
 
 Plugins should be members of the plugin package and require
@@ -29,10 +62,17 @@ A Connection is a way for the chatbot to interface with a 3rd party communicatio
 package bot
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/handwritingio/deckard-bot/audit"
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/connection"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
@@ -41,15 +81,116 @@ import (
 
 // Deckard is the object that handles all communication with the plugins and connections
 type Deckard struct {
-	Name             string
-	Plugins          []plugins.Plugin
-	conn             connection.Connection
+	Name    string
+	Plugins []plugins.Plugin
+
+	// PluginTimeout is the longest a plugin's HandleMessage may run for a single
+	// message. Zero or less means plugins are never timed out.
+	PluginTimeout time.Duration
+
+	// ShutdownTimeout is how long Run waits for in-flight messages to be
+	// answered after it has been asked to stop, before closing the connection
+	ShutdownTimeout time.Duration
+
+	// Admins are the IDs of the users allowed to manage plugins with the
+	// !plugin command and roles with !access. They have the admin role,
+	// along with its members in Roles. Until somebody is an admin, nobody
+	// can.
+	Admins []string
+
+	// Roles lists the members of each role: user IDs, or group:name
+	// for everyone in one of the Groups. Admins have every role. Roles can
+	// also be granted and revoked at runtime with !access.
+	Roles map[string][]string
+
+	// Groups are named lists of user IDs
+	Groups map[string][]string
+
+	// PanicLimit is how many times a plugin may panic within PanicWindow
+	// before it's quarantined and stops receiving messages. Zero or less
+	// means plugins are never quarantined.
+	PanicLimit  int
+	PanicWindow time.Duration
+
+	// RateLimits stop users spamming plugins. They're read when the first
+	// message is handled, so changes after that are ignored.
+	RateLimits RateLimits
+
+	// Brain is where plugins keep their state. Each BrainPlugin is given its
+	// own namespace of it. It's closed when Run returns.
+	Brain brain.Store
+
+	// Audit records every command that's run. If it's nil, the most recent
+	// commands are kept in memory. It's closed when Run returns.
+	Audit audit.Log
+
+	// Clock tells the scheduler the time. If it's nil the real time is used.
+	Clock Clock
+
+	// MetricsAddr is the address Run serves Prometheus metrics on, at
+	// /metrics, e.g. ":9090". The liveness and readiness checks are served
+	// there too, at /healthz and /readyz. If it's empty they're only
+	// available from MetricsHandler, LivenessHandler and ReadinessHandler.
+	MetricsAddr string
+
+	// HealthTimeout is how long a connection may go without its keepalive
+	// being answered before the bot is no longer live. Zero or less means
+	// keepalives aren't checked.
+	HealthTimeout time.Duration
+
+	conns            []*attachedConnection
 	pluginInitResult chan pluginResult
+	pluginsMu        sync.RWMutex
+	records          []*pluginRecord // every plugin ever added, guarded by pluginsMu
+	pluginCmdsOnce   sync.Once
+	pluginCmds       *plugins.Router
+	accessCmdsOnce   sync.Once
+	accessCmds       *plugins.Router
+
+	grantsOnce sync.Once
+	grants     *grants
+
+	metricsOnce sync.Once
+	metricsV    *botMetrics
+
+	auditOnce     sync.Once
+	auditCmdsOnce sync.Once
+	auditCmds     *plugins.Router
+
+	panicsMu sync.Mutex
+	panics   map[string][]time.Time // recent panics by plugin name
+
+	middleware middlewares
+
+	limitersOnce sync.Once
+	limiters     *limiters
+
+	schedulerOnce sync.Once
+	sched         *scheduler
+
+	convos conversations
+
+	inflight sync.WaitGroup // messages currently being handled
+
+	// quit is closed once Run has stopped waiting for in-flight messages,
+	// so that late replies and plugins that finish starting afterwards give
+	// up rather than block forever
+	quitOnce, quitCloseOnce sync.Once
+	quit                    chan struct{}
+	runMu    sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{} // closed when Run returns
 }
 
 type pluginResult struct {
 	Plugin plugins.Plugin
 	Error  error
+
+	// Generation is which of the plugin's starts this is the result of
+	Generation int
+
+	// done is closed once the result has been handled
+	done chan struct{}
 }
 
 // buildTime and version come from a linker flag during build time.
@@ -62,14 +203,6 @@ var (
 
 func init() {
 	log.Printf("Version: %s, Build Time: %s", version, buildTime)
-
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt, os.Kill, syscall.SIGTERM)
-
-	go func() {
-		sig := <-ch
-		log.Fatalf("Received Signal: %s", sig)
-	}()
 }
 
 // AddPlugin call's the plugin's OnInit() method in an anonymous goroutine
@@ -78,23 +211,46 @@ func init() {
 // This method is async to support plugins that require more startup time to
 // not block the main loop of the bot
 func (d *Deckard) AddPlugin(p plugins.Plugin) {
-	go func() {
-		d.pluginInitResult <- pluginResult{
-			p, p.OnInit(),
-		}
-	}()
+	d.addPlugin(p, "")
 }
 
-// New creates a new Bot with a name, new connection, and plugins.
-func New(name string, conn connection.Connection, p ...plugins.Plugin) *Deckard {
-	d := &Deckard{
-		Name:             name,
-		Plugins:          make([]plugins.Plugin, 0),
-		pluginInitResult: make(chan pluginResult),
+// addPlugin adds the plugin, remembering the name it was registered under
+// if it was created from the plugin registry
+func (d *Deckard) addPlugin(p plugins.Plugin, registeredAs string) {
+	d.pluginsMu.Lock()
+	defer d.pluginsMu.Unlock()
+	rec := &pluginRecord{plugin: p, registeredAs: registeredAs}
+	d.records = append(d.records, rec)
+	d.startPlugin(rec)
+}
+
+// New creates a new Bot with a name, new connection, and plugins. Its other
+// settings are the configuration defaults, overridden by the environment.
+// More connections can be attached with AddConnection. It returns an error
+// if the environment is invalid, the brain or audit log can't be opened, or
+// the connection added.
+func New(name string, conn connection.Connection, p ...plugins.Plugin) (*Deckard, error) {
+	f, err := config.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	d, err := fromSettings(f)
+	if err != nil {
+		return nil, err
+	}
+	d.Name = name
+
+	// Open the brain before any plugin needs it
+	if err := d.open(f.BrainPath, f.Audit.Path, f.Audit.MaxSizeMB, f.Audit.MaxBackups); err != nil {
+		return nil, err
 	}
 
 	// Set the connection
-	d.conn = conn
+	if conn != nil {
+		if err := d.AddConnection("", conn); err != nil {
+			return nil, err
+		}
+	}
 
 	// Add plugins
 	for _, plugin := range p {
@@ -102,75 +258,316 @@ func New(name string, conn connection.Connection, p ...plugins.Plugin) *Deckard
 	}
 
 	log.Infof("Bot named %s Created", name)
-	return d
+	return d, nil
 }
 
-// Go starts the TX/RX channels and starts the message pump
-// Both are goroutines and exit the bot if anything enters
-// the errorChannel
+// open opens the brain and audit log at their paths, if they're set. The
+// audit log is rotated once it's maxSizeMB megabytes.
+func (d *Deckard) open(brainPath, auditPath string, maxSizeMB, maxBackups int) error {
+	if brainPath != "" {
+		b, err := brain.Open(brainPath)
+		if err != nil {
+			return fmt.Errorf("could not open the brain at %s: %s", brainPath, err.Error())
+		}
+		d.Brain = b
+	}
+	if auditPath != "" {
+		l, err := audit.Open(auditPath, int64(maxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return fmt.Errorf("could not open the audit log at %s: %s", auditPath, err.Error())
+		}
+		d.Audit = l
+	}
+	return nil
+}
+
+// Go runs the bot until the process receives SIGINT or SIGTERM
+// and exits the program if the bot stops because of an error
 func (d *Deckard) Go() {
-	errorChannel := make(chan error)
-	rx, tx := d.conn.Start(errorChannel)
-	go d.waitForPlugins()
-	go d.messagePump(rx, tx)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(ch)
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Infof("Received Signal: %s", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := d.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Run starts the TX/RX channels of every connection, the plugin registration
+// loop and the message pumps, then blocks until ctx is cancelled, Stop is called
+// or a connection sends an error. Once stopped, in-flight messages are given
+// ShutdownTimeout to finish before the connections are closed. Run returns the
+// connection's error, or nil if the bot was asked to stop.
+func (d *Deckard) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d.runMu.Lock()
+	d.cancel = cancel
+	d.done = make(chan struct{})
+	d.runMu.Unlock()
+	defer close(d.done)
+
+	errorChannel := make(chan connectionError)
+	d.startConnections(ctx, errorChannel)
+	go d.waitForPlugins(ctx)
+	go d.serveMetrics(ctx)
+
+	pumpsDone := make(chan struct{})
+	go func() {
+		d.runPumps(ctx)
+		close(pumpsDone)
+	}()
+
 	var err error
-	err = <-errorChannel
-	log.Fatal(err)
+	select {
+	case <-ctx.Done():
+		log.Info("Shutting down")
+	case connErr := <-errorChannel:
+		d.metrics().connectionErrors.Inc(connErr.name)
+		err = connErr.err
+		log.WithFields(log.Fields{
+			"Connection": connErr.name,
+			"Error":      err.Error(),
+		}).Error("Connection failed, shutting down")
+	}
+
+	// Stop taking new messages, then let the ones already in flight finish
+	cancel()
+	<-pumpsDone
+	d.drain()
+	d.quitCloseOnce.Do(func() { close(d.quitting()) })
+	d.shutdownPlugins()
+	d.closeBrain()
+	d.closeAudit()
+
+	if closeErr := d.closeConnections(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	log.Infof("Bot named %s Stopped", d.Name)
+	return err
+}
+
+// Stop asks a running bot to shut down and waits for Run to return.
+// It does nothing if the bot isn't running.
+func (d *Deckard) Stop() {
+	d.runMu.Lock()
+	cancel, done := d.cancel, d.done
+	d.runMu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
 }
 
-func (d *Deckard) waitForPlugins() {
+// drain waits up to ShutdownTimeout for in-flight messages to be handled
+func (d *Deckard) drain() {
+	drained := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(drained)
+	}()
+
+	if d.ShutdownTimeout <= 0 {
+		<-drained
+		return
+	}
+	timer := time.NewTimer(d.ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		log.Warnf("Gave up waiting for in-flight messages after %s", d.ShutdownTimeout)
+	}
+}
+
+// quitting returns the channel that's closed once Run has stopped waiting
+// for in-flight messages
+func (d *Deckard) quitting() chan struct{} {
+	d.quitOnce.Do(func() { d.quit = make(chan struct{}) })
+	return d.quit
+}
+
+// waitForPlugins registers each plugin as it finishes starting, until ctx
+// is done
+func (d *Deckard) waitForPlugins(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case result := <-d.pluginInitResult:
-			fields := log.Fields{
-				"Plugin": result.Plugin.Name(),
-			}
-			if result.Error != nil {
-				fields["Error"] = result.Error.Error()
-				log.WithFields(fields).Warn("Plugin Registration Failed")
-			} else {
-				d.Plugins = append(d.Plugins, result.Plugin)
-				log.WithFields(fields).Info("Plugin Registered")
-			}
+			d.registerPlugin(result)
 		}
 	}
 }
 
-// messagePump distributes messages via the RX channel
-// to each plugin's HandleMessage method and returns
-// HandleMessage message response to the TX channel
-func (d *Deckard) messagePump(rx, tx message.BasicChannel) {
+// messagePump reads messages off a connection's RX channel and hands each one
+// to handleMessage on its own goroutine, so that a slow plugin never blocks
+// the next message from being read. Replies go out on the same connection's
+// TX channel. It returns once ctx is cancelled.
+func (d *Deckard) messagePump(ctx context.Context, name string, rx, tx message.BasicChannel) {
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case in := <-rx:
 			if in.Text == "" {
 				continue
 			}
-
-			// Check if the message is meant for internal plugin
-			// and don't send it to other plugins if it's meant for internal
-			// Messages meant for internal responses should not make it to plugins
-			internalResponse := d.pluginInternal(in)
-			if internalResponse.Finished {
-				tx <- internalResponse
-				continue
+			if name != "" {
+				in.Connection = name
 			}
-			for _, p := range d.Plugins {
-				if !p.Regexp().MatchString(in.Text) {
-					log.Debugf("Message did not match regex for plugin %s... skipping", p.Name())
-					continue
-				}
-				log.Infof("Message matches regex for plugin %s... sending message to plugin", p.Name())
-				out := p.HandleMessage(in)
-				out.ID = in.ID       // copy the id from the incoming message
-				out.Finished = false // we're not done til we exit this loop
-				if out.Text != "" {
-					log.Infof("Incoming message: %#v", in)
-					log.Infof("Outgoing message: %#v", out)
-					tx <- out
-				}
-			}
-			tx <- message.Basic{ID: in.ID, Text: "", Finished: true}
+			d.metrics().received.Inc(in.Connection)
+			d.inflight.Add(1)
+			go func() {
+				defer d.inflight.Done()
+				d.handleMessage(in, tx)
+			}()
+		}
+	}
+}
+
+// handleMessage passes a message through the middleware to each matching
+// plugin's HandleMessage method and returns each response to the TX channel.
+// The Finished message is sent once the middleware and all plugins are done.
+func (d *Deckard) handleMessage(in message.Basic, tx message.BasicChannel) {
+	r := newResponder(in, tx, d.quitting())
+	d.handler()(in, ResponderFunc(func(out message.Basic) {
+		if out.Text != "" || len(out.Blocks) > 0 {
+			d.metrics().sent.Inc(in.Connection)
 		}
+		r.Send(out)
+	}))
+	select {
+	case tx <- message.Basic{ID: in.ID, Text: "", Finished: true}:
+	case <-d.quitting():
+	}
+}
+
+// dispatch sends the message to every matching plugin concurrently and
+// waits for them all to reply or time out, once it has checked the rate
+// limits. If a plugin is waiting for the message as the next part of a
+// conversation, it goes to that plugin alone. It's the end of the
+// middleware chain.
+func (d *Deckard) dispatch(in message.Basic, r plugins.Responder) {
+	if cl := d.claimFor(in); cl != nil {
+		// it's the answer to a plugin's question
+		defer d.finishClaim(in, cl)
+		if !d.requireRole(cl.role, cl.plugin.Name(), in, r) {
+			return
+		}
+		if !d.allowMessage(in, r) {
+			d.audit(in, cl.plugin.Name(), audit.Denied, time.Now())
+			return
+		}
+		d.callPlugin(cl.plugin, in, r, cl.handle)
+		return
+	}
+
+	// Plugins the sender isn't allowed to use don't spend their tokens
+	var allowed []plugins.Plugin
+	for _, p := range d.matchingPlugins(in) {
+		if d.authorized(p, in, r) {
+			allowed = append(allowed, p)
+		}
+	}
+	if len(allowed) == 0 {
+		return
+	}
+	if !d.allowMessage(in, r) {
+		for _, p := range allowed {
+			d.audit(in, p.Name(), audit.Denied, time.Now())
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range allowed {
+		if !d.allowPlugin(p, in, r) {
+			d.audit(in, p.Name(), audit.Denied, time.Now())
+			continue
+		}
+		wg.Add(1)
+		go func(p plugins.Plugin) {
+			defer wg.Done()
+			d.callPlugin(p, in, r, pluginHandler(p))
+		}(p)
+	}
+	wg.Wait()
+}
+
+// matchingPlugins returns the registered plugins whose regexp matches the message
+func (d *Deckard) matchingPlugins(in message.Basic) (matched []plugins.Plugin) {
+	d.pluginsMu.RLock()
+	defer d.pluginsMu.RUnlock()
+	for _, p := range d.Plugins {
+		if !p.Regexp().MatchString(in.Text) {
+			log.Debugf("Message did not match regex for plugin %s... skipping", p.Name())
+			continue
+		}
+		log.Infof("Message matches regex for plugin %s... sending message to plugin", p.Name())
+		d.metrics().matches.Inc(p.Name())
+		matched = append(matched, p)
+	}
+	return
+}
+
+// callPlugin hands the message to one of the plugin's handlers and waits up
+// to PluginTimeout for it to finish replying. If the plugin takes too long, a
+// timeout message is sent in its place and anything it sends afterwards is
+// discarded. How it went is recorded in the audit log.
+func (d *Deckard) callPlugin(p plugins.Plugin, in message.Basic, out plugins.Responder, handle plugins.ReplyHandler) {
+	start := time.Now()
+	r := newClosableResponder(in, out)
+	defer r.close()
+	if d.PluginTimeout <= 0 {
+		d.audit(in, p.Name(), d.safeInvokePlugin(p, in, r, handle), start)
+		d.metrics().duration.Observe(time.Since(start).Seconds(), p.Name())
+		return
+	}
+
+	done := make(chan audit.Outcome, 1)
+	go func() {
+		done <- d.safeInvokePlugin(p, in, r, handle)
+	}()
+
+	timer := time.NewTimer(d.PluginTimeout)
+	defer timer.Stop()
+	select {
+	case outcome := <-done:
+		d.audit(in, p.Name(), outcome, start)
+		d.metrics().duration.Observe(time.Since(start).Seconds(), p.Name())
+	case <-timer.C:
+		d.audit(in, p.Name(), audit.TimedOut, start)
+		d.metrics().timeouts.Inc(p.Name())
+		log.WithFields(log.Fields{
+			"Plugin":  p.Name(),
+			"Timeout": d.PluginTimeout.String(),
+			"Text":    in.Text,
+		}).Warn("Plugin timed out")
+		r.Send(message.Basic{Text: fmt.Sprintf("Sorry, plugin %s timed out", p.Name())})
+	}
+}
+
+// pluginHandler returns the plugin's HandleMessageStream if it has one, or
+// a handler that sends the return value of HandleMessage otherwise
+func pluginHandler(p plugins.Plugin) plugins.ReplyHandler {
+	if sp, ok := p.(plugins.StreamingPlugin); ok {
+		return sp.HandleMessageStream
+	}
+	return func(in message.Basic, r plugins.Responder) {
+		r.Send(p.HandleMessage(in))
 	}
 }
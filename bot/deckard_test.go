@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// testPlugin is a minimal plugin whose HandleMessage sleeps for delay
+// before replying with reply
+type testPlugin struct {
+	name  string
+	reply string
+	delay time.Duration
+}
+
+func (p *testPlugin) Name() string           { return p.name }
+func (p *testPlugin) Usage() string          { return "`!test`" }
+func (p *testPlugin) Command() []string      { return []string{"!test"} }
+func (p *testPlugin) OnInit() error          { return nil }
+func (p *testPlugin) Regexp() *regexp.Regexp { return regexp.MustCompile(`^!test`) }
+func (p *testPlugin) HandleMessage(in message.Basic) (out message.Basic) {
+	time.Sleep(p.delay)
+	out.Text = p.reply
+	return
+}
+
+// collect reads messages off tx until the Finished message arrives
+func collect(t *testing.T, tx message.BasicChannel) (texts []string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case out := <-tx:
+			if out.Finished {
+				sort.Strings(texts)
+				return
+			}
+			texts = append(texts, out.Text)
+		case <-timeout:
+			t.Fatal("never received the Finished message")
+		}
+	}
+}
+
+func TestHandleMessageTimeout(t *testing.T) {
+	d := &Deckard{
+		Plugins: []plugins.Plugin{
+			&testPlugin{name: "Fast", reply: "fast reply"},
+			&testPlugin{name: "Slow", reply: "slow reply", delay: time.Second},
+		},
+		PluginTimeout: 50 * time.Millisecond,
+	}
+	tx := make(message.BasicChannel)
+	go d.handleMessage(message.Basic{ID: 1, Text: "!test"}, tx)
+
+	got := collect(t, tx)
+	want := []string{"Sorry, plugin Slow timed out", "fast reply"}
+	if len(got) != len(want) {
+		t.Fatalf("got replies %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got replies %q, want %q", got, want)
+		}
+	}
+}
+
+func TestHandleMessageConcurrent(t *testing.T) {
+	d := &Deckard{
+		Plugins: []plugins.Plugin{
+			&testPlugin{name: "One", reply: "one", delay: 200 * time.Millisecond},
+			&testPlugin{name: "Two", reply: "two", delay: 200 * time.Millisecond},
+			&testPlugin{name: "Three", reply: "three", delay: 200 * time.Millisecond},
+		},
+		PluginTimeout: time.Second,
+	}
+	tx := make(message.BasicChannel)
+	start := time.Now()
+	go d.handleMessage(message.Basic{ID: 1, Text: "!test"}, tx)
+
+	got := collect(t, tx)
+	if len(got) != 3 {
+		t.Fatalf("got %d replies, want 3: %q", len(got), got)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("plugins were not called concurrently, took %s", elapsed)
+	}
+}
+
+// testConnection is a Connection whose channels are driven by the test
+type testConnection struct {
+	rx, tx message.BasicChannel
+	err    error
+	closed chan struct{}
+}
+
+func newTestConnection() *testConnection {
+	return &testConnection{
+		rx:     make(message.BasicChannel),
+		tx:     make(message.BasicChannel),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *testConnection) Start(errorChannel chan error) (rx, tx message.BasicChannel) {
+	if c.err != nil {
+		errorChannel <- c.err
+	}
+	return c.rx, c.tx
+}
+
+func (c *testConnection) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRunStopDrainsInflight(t *testing.T) {
+	conn := newTestConnection()
+	d, err := New("Test", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Plugins = []plugins.Plugin{
+		&testPlugin{name: "Slow", reply: "slow reply", delay: 100 * time.Millisecond},
+	}
+
+	runErr := make(chan error)
+	go func() {
+		runErr <- d.Run(context.Background())
+	}()
+	conn.rx <- message.Basic{ID: 1, Text: "!test"}
+
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+
+	// the in-flight reply must still make it out before the connection closes
+	if got := collect(t, conn.tx); len(got) != 1 || got[0] != "slow reply" {
+		t.Errorf("got replies %q, want [\"slow reply\"]", got)
+	}
+	<-stopped
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("connection was not closed")
+	}
+	if err := <-runErr; err != nil {
+		t.Errorf("Run returned %v, want nil", err)
+	}
+}
+
+func TestRunReturnsConnectionError(t *testing.T) {
+	conn := newTestConnection()
+	conn.err = errors.New("boom")
+	d, err := New("Test", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Run(context.Background()); err != conn.err {
+		t.Errorf("Run returned %v, want %v", err, conn.err)
+	}
+}
+
+// lateStartPlugin doesn't finish starting until it's told to, and says
+// when it's shut down
+type lateStartPlugin struct {
+	slowPlugin
+	shutdown chan struct{}
+}
+
+func (p *lateStartPlugin) OnShutdown() error {
+	close(p.shutdown)
+	return nil
+}
+
+func TestStopGivesUpOnLateReplies(t *testing.T) {
+	conn := newTestConnection()
+	d := &Deckard{
+		Plugins: []plugins.Plugin{
+			&testPlugin{name: "Slow", reply: "too late", delay: 200 * time.Millisecond},
+		},
+		ShutdownTimeout:  20 * time.Millisecond,
+		pluginInitResult: make(chan pluginResult),
+	}
+	if err := d.AddConnection("", conn); err != nil {
+		t.Fatal(err)
+	}
+	late := &lateStartPlugin{
+		slowPlugin: slowPlugin{testPlugin: testPlugin{name: "Late"}, started: make(chan struct{})},
+		shutdown:   make(chan struct{}),
+	}
+	d.AddPlugin(late)
+
+	go d.Run(context.Background())
+	conn.rx <- testMessage
+	d.Stop()
+
+	// nothing reads the connection's TX channel or the plugin results now,
+	// but neither the message's handler nor the late plugin may block
+	close(late.started)
+	handled := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the message's handler blocked sending its reply after the bot stopped")
+	}
+	select {
+	case <-late.shutdown:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the plugin that finished starting after the bot stopped wasn't shut down")
+	}
+}
+
+// streamingTestPlugin sends each of its replies through the Responder
+type streamingTestPlugin struct {
+	testPlugin
+	replies []string
+}
+
+func (p *streamingTestPlugin) HandleMessageStream(in message.Basic, r plugins.Responder) {
+	for _, reply := range p.replies {
+		r.Send(message.Basic{Text: reply})
+	}
+}
+
+func TestHandleMessageStream(t *testing.T) {
+	d := &Deckard{
+		Plugins: []plugins.Plugin{
+			&streamingTestPlugin{
+				testPlugin: testPlugin{name: "Stream"},
+				replies:    []string{"working on it", "", "done"},
+			},
+		},
+		PluginTimeout: time.Second,
+	}
+	tx := make(message.BasicChannel)
+	go d.handleMessage(message.Basic{ID: 7, Text: "!test"}, tx)
+
+	got := collect(t, tx)
+	if len(got) != 2 || got[0] != "done" || got[1] != "working on it" {
+		t.Errorf("got replies %q, want [\"done\" \"working on it\"]", got)
+	}
+}
+
+// testMessage matches every testPlugin
+var testMessage = message.Basic{ID: 1, Text: "!test"}
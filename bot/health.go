@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/connection"
+)
+
+// Health is how the bot is doing, as reported by its health checks
+type Health struct {
+	// Live is false if the bot isn't running, one of its connections has
+	// given up reconnecting, or one stopped answering its keepalive while
+	// connected
+	Live bool `json:"live"`
+
+	// Ready is true once the bot is live, every connection is connected and
+	// every plugin has finished starting. Plugins that failed don't stop the
+	// bot being ready.
+	Ready bool `json:"ready"`
+
+	// Problems explains why the bot isn't live or ready
+	Problems []string `json:"problems,omitempty"`
+
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time"`
+
+	Connections []ConnectionHealth `json:"connections"`
+	Plugins     []PluginStatus     `json:"plugins"`
+}
+
+// ConnectionHealth is how one of the bot's connections is doing
+type ConnectionHealth struct {
+	Name string `json:"name"`
+
+	// Connected, GaveUp, LastEvent and LastPong are as reported by the
+	// connection, if it's a StatusReporter
+	Connected bool       `json:"connected"`
+	GaveUp    bool       `json:"gave_up,omitempty"`
+	LastEvent *time.Time `json:"last_event,omitempty"`
+	LastPong  *time.Time `json:"last_pong,omitempty"`
+
+	// SinceLastEvent and SinceLastPong are how long ago those were,
+	// e.g. "12s"
+	SinceLastEvent string `json:"since_last_event,omitempty"`
+	SinceLastPong  string `json:"since_last_pong,omitempty"`
+}
+
+// Health checks how the bot is doing
+func (d *Deckard) Health() Health {
+	now := time.Now()
+	h := Health{
+		Version:   version,
+		BuildTime: buildTime,
+		Plugins:   d.PluginStatus(),
+	}
+
+	d.runMu.Lock()
+	running := d.cancel != nil
+	select {
+	case <-d.done:
+		running = false
+	default:
+	}
+	conns := d.conns
+	d.runMu.Unlock()
+	if !running {
+		h.Problems = append(h.Problems, "the bot isn't running")
+	}
+
+	// a connection that's reconnecting isn't ready, but only one that gave
+	// up means the bot needs restarting
+	var disconnected []string
+	for _, ac := range conns {
+		ch := ConnectionHealth{Name: ac.label(), Connected: true}
+		if sr, ok := ac.conn.(connection.StatusReporter); ok {
+			status := sr.Status()
+			ch.Connected = status.Connected
+			ch.GaveUp = status.GaveUp
+			if !status.LastEvent.IsZero() {
+				ch.LastEvent = &status.LastEvent
+				ch.SinceLastEvent = since(now, status.LastEvent)
+			}
+			if !status.LastPong.IsZero() {
+				ch.LastPong = &status.LastPong
+				ch.SinceLastPong = since(now, status.LastPong)
+				if ch.Connected && d.HealthTimeout > 0 && now.Sub(status.LastPong) > d.HealthTimeout {
+					h.Problems = append(h.Problems, fmt.Sprintf("connection %s hasn't answered a ping for %s", ch.Name, ch.SinceLastPong))
+				}
+			}
+		}
+		switch {
+		case ch.GaveUp:
+			h.Problems = append(h.Problems, fmt.Sprintf("connection %s gave up reconnecting", ch.Name))
+		case !ch.Connected:
+			disconnected = append(disconnected, fmt.Sprintf("connection %s isn't connected", ch.Name))
+		}
+		h.Connections = append(h.Connections, ch)
+	}
+	h.Live = len(h.Problems) == 0
+
+	h.Problems = append(h.Problems, disconnected...)
+	for _, p := range h.Plugins {
+		if p.State == PluginStarting {
+			h.Problems = append(h.Problems, fmt.Sprintf("plugin %s is still starting", p.Name))
+		}
+	}
+	h.Ready = len(h.Problems) == 0
+	return h
+}
+
+// since is how long ago t was, to the second
+func since(now, t time.Time) string {
+	return (now.Sub(t) / time.Second * time.Second).String()
+}
+
+// LivenessHandler answers 200 while the bot is live and 503 otherwise,
+// with the bot's Health as JSON
+func (d *Deckard) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := d.Health()
+		writeHealth(w, h, h.Live)
+	})
+}
+
+// ReadinessHandler answers 200 while the bot is ready and 503 otherwise,
+// with the bot's Health as JSON
+func (d *Deckard) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := d.Health()
+		writeHealth(w, h, h.Ready)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, h Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/connection"
+)
+
+// slowPlugin doesn't finish starting until it's told to
+type slowPlugin struct {
+	testPlugin
+	started chan struct{}
+}
+
+func (p *slowPlugin) OnInit() error {
+	<-p.started
+	return nil
+}
+
+func TestHealth(t *testing.T) {
+	conn := &statusConnection{testConnection: newTestConnection(), status: connection.Status{
+		Connected: true,
+	}}
+	setStatus := func(fn func(*connection.Status)) {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		fn(&conn.status)
+	}
+	slow := &slowPlugin{testPlugin: testPlugin{name: "Slow"}, started: make(chan struct{})}
+	d := &Deckard{HealthTimeout: time.Minute, pluginInitResult: make(chan pluginResult)}
+	d.AddConnection("slack", conn)
+	d.AddPlugin(slow)
+	d.AddPlugin(&flakyPlugin{testPlugin: testPlugin{name: "Flaky"}})
+
+	check := func(live, ready bool) Health {
+		h := d.Health()
+		if h.Live != live || h.Ready != ready {
+			t.Fatalf("got live %v ready %v, want %v %v: %q", h.Live, h.Ready, live, ready, h.Problems)
+		}
+		return h
+	}
+	check(false, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	waitForState(t, d, "Flaky", PluginFailed)
+	check(true, false)
+
+	// A failed plugin doesn't stop the bot being ready
+	close(slow.started)
+	waitForState(t, d, "Slow", PluginRegistered)
+	setStatus(func(status *connection.Status) {
+		status.LastEvent = time.Now().Add(-10 * time.Second)
+		status.LastPong = time.Now().Add(-5 * time.Second)
+	})
+	h := check(true, true)
+	if c := h.Connections[0]; c.Name != "slack" || c.SinceLastEvent != "10s" || c.SinceLastPong != "5s" {
+		t.Errorf("got connection health %+v", c)
+	}
+
+	rec := httptest.NewRecorder()
+	d.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var body Health
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 200 || len(body.Plugins) != 2 || body.Plugins[1].Error != "service unavailable" {
+		t.Errorf("got /readyz %d %+v", rec.Code, body)
+	}
+
+	// Slack stops answering pings
+	setStatus(func(status *connection.Status) { status.LastPong = time.Now().Add(-2 * time.Minute) })
+	check(false, false)
+	rec = httptest.NewRecorder()
+	d.LivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != 503 {
+		t.Errorf("got /healthz %d, want 503", rec.Code)
+	}
+
+	// Reconnecting only makes the bot unready, until the connection gives up
+	setStatus(func(status *connection.Status) { status.Connected = false })
+	if h := check(true, false); len(h.Problems) != 1 || h.Problems[0] != "connection slack isn't connected" {
+		t.Errorf("got problems %q", h.Problems)
+	}
+	setStatus(func(status *connection.Status) { status.GaveUp = true })
+	if h := check(false, false); len(h.Problems) != 1 || h.Problems[0] != "connection slack gave up reconnecting" {
+		t.Errorf("got problems %q", h.Problems)
+	}
+}
@@ -1,12 +1,19 @@
 package bot
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/handwritingio/deckard-bot/audit"
 	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
 )
 
+// builtinPlugin is the plugin name Deckard's own commands are audited under
+const builtinPlugin = "Deckard"
+
 var (
 	// Regex for messages that should only be answered by Deckard
 	// These messages won't be sent to plugins
@@ -14,39 +21,94 @@ var (
 	reDeckardWho  = regexp.MustCompile("(?i)^!who$")
 )
 
-func (d *Deckard) pluginInternal(in message.Basic) message.Basic {
+// builtinCommands is the middleware that answers the messages meant for
+// Deckard itself. Those messages aren't passed on to the plugins.
+func (d *Deckard) builtinCommands(next Handler) Handler {
+	return func(in message.Basic, r plugins.Responder) {
+		if d.pluginInternal(in, r) {
+			return
+		}
+		next(in, r)
+	}
+}
+
+// pluginInternal answers the messages meant for Deckard itself, and
+// reports whether it did so the message isn't sent on to plugins
+func (d *Deckard) pluginInternal(in message.Basic, r plugins.Responder) bool {
 	switch {
 	case reDeckardHelp.MatchString(in.Text):
+		start := time.Now()
 		cmd := reDeckardHelp.FindStringSubmatch(in.Text)
 		plugin := cmd[1]
-		help := strings.Join(d.pluginHelp(plugin), "\n")
-		return message.Basic{ID: in.ID, Text: help, Finished: true}
+		r.Send(message.Rich(d.pluginHelp(plugin)...))
+		d.audit(in, builtinPlugin, audit.Replied, start)
+		return true
 
 	case reDeckardWho.MatchString(in.Text):
+		start := time.Now()
 		who := "Hello, I Am " + d.Name
-		return message.Basic{ID: in.ID, Text: who, Finished: true}
+		r.Send(message.Basic{Text: who})
+		d.audit(in, builtinPlugin, audit.Replied, start)
+		return true
+
+	case d.pluginCommands().Regexp().MatchString(in.Text):
+		d.runBuiltin(d.pluginCommands(), in, r)
+		return true
+
+	case d.accessCommands().Regexp().MatchString(in.Text):
+		d.runBuiltin(d.accessCommands(), in, r)
+		return true
 
+	case d.auditCommands().Regexp().MatchString(in.Text):
+		d.runBuiltin(d.auditCommands(), in, r)
+		return true
 	}
-	return in
+	return false
 }
 
-func (d *Deckard) pluginHelp(plugin string) (s []string) {
+// runBuiltin runs one of Deckard's own commands if the sender has the role
+// it needs, and records it in the audit log
+func (d *Deckard) runBuiltin(commands *plugins.Router, in message.Basic, r plugins.Responder) {
+	if !d.requireRole(commands.Role(in), builtinPlugin, in, r) {
+		return
+	}
+	start := time.Now()
+	commands.HandleMessageStream(in, r)
+	d.audit(in, builtinPlugin, audit.Replied, start)
+}
+
+// pluginHelp returns the plugin's usage, or the list of every plugin and
+// its commands if plugin is empty
+func (d *Deckard) pluginHelp(plugin string) (help []message.Block) {
+	available := d.available()
+	d.pluginsMu.RLock()
+	defer d.pluginsMu.RUnlock()
+
 	if plugin != "" {
 		// Return the specified plugin's usage
 		for _, p := range d.Plugins {
 			if strings.ToLower(plugin) == strings.ToLower(p.Name()) {
-				s = append(s, "**Usage for `"+p.Name()+"` Plugin**")
-				s = append(s, p.Usage())
+				help = append(help,
+					message.Section{Text: "**Usage for `" + p.Name() + "` Plugin**"},
+					message.Section{Text: p.Usage()},
+				)
 				return
 			}
 		}
 	} else {
 		// Return the list of plugins and commands
-		s = append(s, "*Here's a list of all known commands:*")
+		help = append(help, message.Section{Text: "*Here's a list of all known commands:*"})
+		s := []string{}
 		for _, r := range d.Plugins {
 			command := formatCommands(r.Command())
 			s = append(s, "• Plugin *"+r.Name()+"* -- "+command)
 		}
+		if len(s) > 0 {
+			help = append(help, message.Section{Text: strings.Join(s, "\n")})
+		}
+		if len(available) > 0 {
+			help = append(help, message.Context{"*These plugins are available but not enabled:* " + strings.Join(available, ", ")})
+		}
 	}
 	return
 }
@@ -57,3 +119,93 @@ func formatCommands(cmd []string) string {
 	}
 	return strings.Join(s, " ")
 }
+
+// pluginCommands returns the router for the !plugin admin command
+func (d *Deckard) pluginCommands() *plugins.Router {
+	d.pluginCmdsOnce.Do(func() {
+		name := []plugins.Arg{{Name: "name", Type: plugins.TextArg}}
+		d.pluginCmds = plugins.NewRouter(&plugins.Command{
+			Name: "!plugin",
+			Role: AdminRole,
+			Subcommands: []*plugins.Command{
+				{
+					Name:        "list",
+					Description: "lists every plugin with its state and last error",
+					Handler:     d.handlePluginList,
+				},
+				{
+					Name:        "enable",
+					Description: "starts a disabled, failed or quarantined plugin",
+					Args:        name,
+					StreamHandler: func(in message.Basic, args plugins.Args, r plugins.Responder) {
+						result, err := d.enablePlugin(args.String("name"))
+						d.reportPluginStart(args.String("name"), result, err, r)
+					},
+				},
+				{
+					Name:        "disable",
+					Description: "stops sending messages to a plugin",
+					Args:        name,
+					Handler: func(in message.Basic, args plugins.Args) (out message.Basic) {
+						if err := d.disablePlugin(args.String("name")); err != nil {
+							out.Text = "Sorry, " + err.Error()
+							return
+						}
+						out.Text = "Disabled " + args.String("name")
+						return
+					},
+				},
+				{
+					Name:        "reload",
+					Description: "shuts a plugin down and starts it again",
+					Args:        name,
+					StreamHandler: func(in message.Basic, args plugins.Args, r plugins.Responder) {
+						result, err := d.reloadPlugin(args.String("name"))
+						d.reportPluginStart(args.String("name"), result, err, r)
+					},
+				},
+			},
+		})
+	})
+	return d.pluginCmds
+}
+
+// handlePluginList replies with the state of every plugin
+func (d *Deckard) handlePluginList(in message.Basic, args plugins.Args) (out message.Basic) {
+	s := []string{"*Plugins:*"}
+	for _, status := range d.PluginStatus() {
+		line := fmt.Sprintf("• *%s* %s since %s", status.Name, status.State, status.Since.Format(time.RFC1123))
+		if status.Error != "" {
+			line += ": " + status.Error
+		}
+		s = append(s, line)
+	}
+	out.Text = strings.Join(s, "\n")
+	return
+}
+
+// reportPluginStart waits for a plugin's OnInit to finish and
+// tells the user how it went
+func (d *Deckard) reportPluginStart(name string, result <-chan error, err error, r plugins.Responder) {
+	if err != nil {
+		r.Send(message.Basic{Text: "Sorry, " + err.Error()})
+		return
+	}
+	r.Send(message.Basic{Text: "Starting " + name + "..."})
+
+	var timeout <-chan time.Time
+	if d.PluginTimeout > 0 {
+		timeout = time.After(d.PluginTimeout)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			r.Send(message.Basic{Text: name + " failed to start: " + err.Error()})
+			return
+		}
+		r.Send(message.Basic{Text: name + " is registered"})
+	case <-timeout:
+		r.Send(message.Basic{Text: name + " is still starting, check `!plugin list` later"})
+	case <-d.quitting():
+	}
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/metrics"
+)
+
+// botMetrics are the metrics the bot keeps about itself and its plugins
+type botMetrics struct {
+	registry *metrics.Registry
+
+	received         *metrics.Counter
+	sent             *metrics.Counter
+	connectionErrors *metrics.Counter
+	matches          *metrics.Counter
+	panics           *metrics.Counter
+	timeouts         *metrics.Counter
+	initErrors       *metrics.Counter
+	duration         *metrics.Histogram
+	inbox            *metrics.Gauge
+	reconnects       *metrics.Counter
+	pluginState      *metrics.Gauge
+
+	// counted is how many of each connection's reconnects have been added
+	// to the reconnects counter
+	countedMu sync.Mutex
+	counted   map[string]int
+}
+
+// metrics returns the bot's metrics, creating them the first time
+func (d *Deckard) metrics() *botMetrics {
+	d.metricsOnce.Do(func() {
+		r := metrics.NewRegistry()
+		m := &botMetrics{
+			registry:         r,
+			received:         r.Counter("deckard_messages_received_total", "Messages received from users.", "connection"),
+			sent:             r.Counter("deckard_messages_sent_total", "Replies and posts sent.", "connection"),
+			connectionErrors: r.Counter("deckard_connection_errors_total", "Errors reported by connections.", "connection"),
+			matches:          r.Counter("deckard_plugin_matches_total", "Messages that matched a plugin's regexp.", "plugin"),
+			panics:           r.Counter("deckard_plugin_panics_total", "Panics recovered from plugins.", "plugin"),
+			timeouts:         r.Counter("deckard_plugin_timeouts_total", "Messages a plugin took longer than the plugin timeout to handle.", "plugin"),
+			initErrors:       r.Counter("deckard_plugin_init_errors_total", "Times a plugin's OnInit failed.", "plugin"),
+			duration:         r.Histogram("deckard_plugin_duration_seconds", "How long plugins took to handle a message.", nil, "plugin"),
+			inbox:            r.Gauge("deckard_inbox_size", "Received messages still waiting for a reply.", "connection"),
+			reconnects:       r.Counter("deckard_connection_reconnects_total", "Times a connection has reconnected.", "connection"),
+			pluginState:      r.Gauge("deckard_plugin_state", "1 for the state each plugin is in.", "plugin", "state"),
+			counted:          make(map[string]int),
+		}
+		r.OnScrape(d.scrapeMetrics)
+		d.metricsV = m
+	})
+	return d.metricsV
+}
+
+// scrapeMetrics sets the gauges that are read from the connections
+// and plugins, and counts the reconnects since the last scrape
+func (d *Deckard) scrapeMetrics() {
+	m := d.metricsV
+	m.inbox.Reset()
+	d.runMu.Lock()
+	conns := d.conns
+	d.runMu.Unlock()
+	for _, ac := range conns {
+		sr, ok := ac.conn.(connection.StatusReporter)
+		if !ok {
+			continue
+		}
+		status := sr.Status()
+		m.inbox.Set(float64(status.Inbox), ac.label())
+		m.countReconnects(ac.label(), status.Reconnects)
+	}
+
+	m.pluginState.Reset()
+	for _, status := range d.PluginStatus() {
+		m.pluginState.Set(1, status.Name, string(status.State))
+	}
+}
+
+// countReconnects adds the connection's reconnects that haven't been
+// counted yet to the reconnects counter
+func (m *botMetrics) countReconnects(label string, reconnects int) {
+	m.countedMu.Lock()
+	defer m.countedMu.Unlock()
+	if reconnects > m.counted[label] {
+		m.reconnects.Add(float64(reconnects-m.counted[label]), label)
+		m.counted[label] = reconnects
+	}
+}
+
+// MetricsHandler serves the bot's metrics in the Prometheus text format,
+// for mounting on a server of your own. Set MetricsAddr to have the bot
+// serve them itself.
+func (d *Deckard) MetricsHandler() http.Handler {
+	return d.metrics().registry
+}
+
+// serveMetrics serves /metrics, /healthz and /readyz on MetricsAddr until
+// ctx is done
+func (d *Deckard) serveMetrics(ctx context.Context) {
+	if d.MetricsAddr == "" {
+		return
+	}
+	l, err := net.Listen("tcp", d.MetricsAddr)
+	if err != nil {
+		log.WithFields(log.Fields{"Addr": d.MetricsAddr, "Error": err.Error()}).Error("Could not serve metrics")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", d.MetricsHandler())
+	mux.Handle("/healthz", d.LivenessHandler())
+	mux.Handle("/readyz", d.ReadinessHandler())
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	log.WithFields(log.Fields{"Addr": l.Addr().String()}).Info("Serving metrics")
+	(&http.Server{Handler: mux, ReadTimeout: 10 * time.Second}).Serve(l)
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// statusConnection is a testConnection that reports a status
+type statusConnection struct {
+	*testConnection
+	mu     sync.Mutex
+	status connection.Status
+}
+
+func (c *statusConnection) Status() connection.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func TestMetrics(t *testing.T) {
+	conn := &statusConnection{testConnection: newTestConnection(), status: connection.Status{Inbox: 2, Reconnects: 1}}
+	d := &Deckard{PluginTimeout: time.Second, pluginInitResult: make(chan pluginResult)}
+	d.AddConnection("chat", conn)
+	d.AddPlugin(&testPlugin{name: "Fast", reply: "fast reply"})
+	d.AddPlugin(&panicPlugin{testPlugin{name: "Panic"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	waitForState(t, d, "Fast", PluginRegistered)
+	waitForState(t, d, "Panic", PluginRegistered)
+
+	conn.rx <- message.Basic{ID: 1, Text: "!test"}
+	collect(t, conn.tx)
+
+	rec := httptest.NewRecorder()
+	d.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`deckard_messages_received_total{connection="chat"} 1`,
+		`deckard_messages_sent_total{connection="chat"} 2`,
+		`deckard_plugin_matches_total{plugin="Fast"} 1`,
+		`deckard_plugin_matches_total{plugin="Panic"} 1`,
+		`deckard_plugin_panics_total{plugin="Panic"} 1`,
+		`deckard_plugin_duration_seconds_count{plugin="Fast"} 1`,
+		`deckard_inbox_size{connection="chat"} 2`,
+		`deckard_connection_reconnects_total{connection="chat"} 1`,
+		`deckard_plugin_state{plugin="Fast",state="registered"} 1`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics are missing %s:\n%s", want, body)
+		}
+	}
+
+	// Reconnects are counted, however often they're scraped
+	conn.mu.Lock()
+	conn.status.Reconnects = 3
+	conn.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		d.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	}
+	body, _ = ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`# TYPE deckard_connection_reconnects_total counter`,
+		`deckard_connection_reconnects_total{connection="chat"} 3`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics are missing %s:\n%s", want, body)
+		}
+	}
+}
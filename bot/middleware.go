@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"sync"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// Handler handles an incoming message, sending any replies through the
+// Responder. The Responder may be called from several goroutines at once,
+// since every matching plugin is called concurrently.
+type Handler func(in message.Basic, r plugins.Responder)
+
+// Middleware wraps the next Handler in the chain. It can inspect or rewrite
+// the incoming message before passing it on, drop it by never calling next,
+// or short-circuit by replying itself. Outgoing replies can be inspected,
+// rewritten or dropped by passing next a Responder that wraps r.
+//
+//	func shout(next bot.Handler) bot.Handler {
+//		return func(in message.Basic, r plugins.Responder) {
+//			next(in, bot.ResponderFunc(func(out message.Basic) {
+//				out.Text = strings.ToUpper(out.Text)
+//				r.Send(out)
+//			}))
+//		}
+//	}
+type Middleware func(next Handler) Handler
+
+// ResponderFunc lets an ordinary function be used as a plugins.Responder
+type ResponderFunc func(out message.Basic)
+
+// Send calls f(out)
+func (f ResponderFunc) Send(out message.Basic) {
+	f(out)
+}
+
+// middlewares holds the middleware added with Use
+type middlewares struct {
+	mu    sync.RWMutex
+	chain []Middleware
+}
+
+// Use adds middleware around message handling. Middleware is called in the
+// order it was added, so the first one added sees each message first and
+// each reply last. The built-in commands (!help, !who, !plugin, !access and
+// !audit) are handled by a middleware that always runs after all of these.
+func (d *Deckard) Use(mw ...Middleware) {
+	d.middleware.mu.Lock()
+	defer d.middleware.mu.Unlock()
+	d.middleware.chain = append(d.middleware.chain, mw...)
+}
+
+// handler builds the chain of middleware that ends with the plugins
+func (d *Deckard) handler() Handler {
+	return d.wrap(d.builtinCommands(d.dispatch))
+}
+
+// wrap wraps h in the middleware added with Use
+func (d *Deckard) wrap(h Handler) Handler {
+	d.middleware.mu.RLock()
+	defer d.middleware.mu.RUnlock()
+	for i := len(d.middleware.chain) - 1; i >= 0; i-- {
+		h = d.middleware.chain[i](h)
+	}
+	return h
+}
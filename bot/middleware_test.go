@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// echoPlugin replies with the text it was sent
+type echoPlugin struct {
+	testPlugin
+}
+
+func (p *echoPlugin) HandleMessage(in message.Basic) (out message.Basic) {
+	out.Text = in.Text
+	return
+}
+
+func TestMiddleware(t *testing.T) {
+	d := &Deckard{
+		Name:          "Test",
+		Plugins:       []plugins.Plugin{&echoPlugin{testPlugin{name: "Echo"}}},
+		PluginTimeout: time.Second,
+	}
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(in message.Basic, r plugins.Responder) {
+				order = append(order, name)
+				next(in, r)
+			}
+		}
+	}
+	// Rewrites incoming messages
+	expand := func(next Handler) Handler {
+		return func(in message.Basic, r plugins.Responder) {
+			in.Text = strings.Replace(in.Text, "!t ", "!test ", 1)
+			next(in, r)
+		}
+	}
+	// Rewrites outgoing replies
+	shout := func(next Handler) Handler {
+		return func(in message.Basic, r plugins.Responder) {
+			next(in, ResponderFunc(func(out message.Basic) {
+				out.Text = strings.ToUpper(out.Text)
+				r.Send(out)
+			}))
+		}
+	}
+	// Short-circuits messages from a blocked user
+	block := func(next Handler) Handler {
+		return func(in message.Basic, r plugins.Responder) {
+			if in.Sender.ID == "troll" {
+				r.Send(message.Basic{Text: "no"})
+				return
+			}
+			next(in, r)
+		}
+	}
+	d.Use(trace("first"), trace("second"), shout, block, expand)
+
+	tests := []struct {
+		in   message.Basic
+		want []string
+	}{
+		{message.Basic{ID: 1, Text: "!t hello"}, []string{"!TEST HELLO"}},
+		{message.Basic{ID: 2, Text: "!who"}, []string{"HELLO, I AM TEST"}},
+		{message.Basic{ID: 3, Text: "!test", Envelope: message.Envelope{Sender: message.User{ID: "troll"}}}, []string{"NO"}},
+	}
+	for _, tt := range tests {
+		order = nil
+		tx := make(message.BasicChannel)
+		go d.handleMessage(tt.in, tx)
+		got := collect(t, tx)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%q got replies %q, want %q", tt.in.Text, got, tt.want)
+		}
+		if strings.Join(order, ",") != "first,second" {
+			t.Errorf("%q ran middleware in order %q, want first,second", tt.in.Text, order)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/audit"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// safeInvokePlugin calls the plugin's handler, but recovers if it panics.
+// The panic is reported and the user gets an apology instead.
+func (d *Deckard) safeInvokePlugin(p plugins.Plugin, in message.Basic, r plugins.Responder, handle plugins.ReplyHandler) (outcome audit.Outcome) {
+	defer func() {
+		if v := recover(); v != nil {
+			d.pluginPanicked(p, in, v, debug.Stack())
+			r.Send(message.Basic{Text: fmt.Sprintf("Sorry, plugin %s ran into a problem handling that", p.Name())})
+			outcome = audit.Errored
+		}
+	}()
+	handle(in, r)
+	return audit.Replied
+}
+
+// safeRunJob runs the job like runJob, but recovers if the middleware it
+// runs through panics
+func (d *Deckard) safeRunJob(j scheduledJob) {
+	p := d.registeredPlugin(j.Plugin)
+	if p == nil {
+		return
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			in := message.Basic{Text: "scheduled job " + j.Job.ID}
+			in.Channel = j.Job.Channel
+			in.Connection = j.Job.Connection
+			d.pluginPanicked(p, in, v, debug.Stack())
+		}
+	}()
+	d.runJob(p, j)
+}
+
+// safeExpire tells the plugin nobody answered its claim, recovering if it
+// panics
+func (d *Deckard) safeExpire(cl *claim) {
+	defer func() {
+		if v := recover(); v != nil {
+			d.pluginPanicked(cl.plugin, cl.in, v, debug.Stack())
+		}
+	}()
+	cl.expired()
+}
+
+// safeInitPlugin calls the plugin's OnInit, turning a panic into an error
+func safeInitPlugin(p plugins.Plugin) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.WithFields(log.Fields{
+				"Plugin": p.Name(),
+				"Panic":  fmt.Sprint(v),
+				"Stack":  string(debug.Stack()),
+			}).Error("Plugin panicked in OnInit")
+			err = fmt.Errorf("panicked: %v", v)
+		}
+	}()
+	return p.OnInit()
+}
+
+// pluginPanicked reports a panic from a plugin's message handler, with the
+// message that triggered it, and quarantines the plugin if it has panicked
+// PanicLimit times within PanicWindow
+func (d *Deckard) pluginPanicked(p plugins.Plugin, in message.Basic, v interface{}, stack []byte) {
+	// Errors are sent to Sentry along with their fields
+	log.WithFields(log.Fields{
+		"Plugin":     p.Name(),
+		"Text":       in.Text,
+		"Sender":     in.Sender.ID,
+		"Channel":    in.Channel,
+		"Connection": in.Connection,
+		"Panic":      fmt.Sprint(v),
+		"Stack":      string(stack),
+	}).Error("Plugin panicked")
+	d.metrics().panics.Inc(p.Name())
+
+	if d.PanicLimit <= 0 {
+		return
+	}
+
+	now := time.Now()
+	d.panicsMu.Lock()
+	if d.panics == nil {
+		d.panics = make(map[string][]time.Time)
+	}
+	var recent []time.Time
+	for _, t := range d.panics[p.Name()] {
+		if now.Sub(t) < d.PanicWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	quarantine := len(recent) >= d.PanicLimit
+	if quarantine {
+		delete(d.panics, p.Name())
+	} else {
+		d.panics[p.Name()] = recent
+	}
+	d.panicsMu.Unlock()
+
+	if quarantine {
+		reason := fmt.Errorf("panicked %d times in %s, last with: %v", len(recent), d.PanicWindow, v)
+		if err := d.quarantinePlugin(p.Name(), reason); err != nil {
+			log.WithFields(log.Fields{
+				"Plugin": p.Name(),
+				"Error":  err.Error(),
+			}).Warn("Could not quarantine plugin")
+			return
+		}
+		log.WithFields(log.Fields{
+			"Plugin": p.Name(),
+			"Reason": reason.Error(),
+		}).Error("Plugin quarantined")
+	}
+}
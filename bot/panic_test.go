@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// panicPlugin panics on every message, like tableflip used to on a bad match
+type panicPlugin struct {
+	testPlugin
+}
+
+func (p *panicPlugin) HandleMessage(in message.Basic) message.Basic {
+	var matches []string
+	return message.Basic{Text: matches[1]}
+}
+
+func TestPanicIsRecoveredAndQuarantined(t *testing.T) {
+	d, err := New("Test", newTestConnection(),
+		&panicPlugin{testPlugin{name: "Panicky"}},
+		&testPlugin{name: "Fine", reply: "fine"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.PanicLimit = 2
+	d.PanicWindow = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.waitForPlugins(ctx)
+	waitForState(t, d, "Panicky", PluginRegistered)
+	waitForState(t, d, "Fine", PluginRegistered)
+
+	tx := make(message.BasicChannel)
+	go d.handleMessage(testMessage, tx)
+	got := collect(t, tx)
+	want := []string{"Sorry, plugin Panicky ran into a problem handling that", "fine"}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got replies %q, want %q", got, want)
+	}
+	waitForState(t, d, "Panicky", PluginRegistered)
+
+	go d.handleMessage(testMessage, tx)
+	collect(t, tx)
+	status := waitForState(t, d, "Panicky", PluginQuarantined)
+	if !strings.HasPrefix(status.Error, "panicked 2 times") {
+		t.Errorf("got quarantine reason %q", status.Error)
+	}
+
+	go d.handleMessage(testMessage, tx)
+	if got := collect(t, tx); len(got) != 1 || got[0] != "fine" {
+		t.Errorf("got replies %q from a quarantined plugin", got)
+	}
+}
@@ -0,0 +1,358 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// PluginState is where a plugin is in its lifecycle
+type PluginState string
+
+// A plugin is starting while its OnInit runs, and is then either registered
+// and receiving messages or failed. An admin can disable it at any time, and
+// it's quarantined if it keeps panicking.
+const (
+	PluginStarting    PluginState = "starting"
+	PluginRegistered  PluginState = "registered"
+	PluginFailed      PluginState = "failed"
+	PluginDisabled    PluginState = "disabled"
+	PluginQuarantined PluginState = "quarantined"
+)
+
+// PluginStatus is a snapshot of a plugin's state
+type PluginStatus struct {
+	Name  string      `json:"name"`
+	State PluginState `json:"state"`
+
+	// Error is why the plugin failed to start or was quarantined
+	Error string `json:"error,omitempty"`
+
+	// Since is when the plugin entered its current state
+	Since time.Time `json:"since"`
+}
+
+// pluginRecord tracks every plugin added to the bot, whether or not it's
+// currently receiving messages. Records are guarded by pluginsMu.
+type pluginRecord struct {
+	plugin plugins.Plugin
+	state  PluginState
+	err    error
+	since  time.Time
+
+	// registeredAs is the plugin's name in the plugin registry, if it
+	// was created from it
+	registeredAs string
+
+	// waiters are told the result of the plugin's current start
+	waiters []chan error
+
+	// generation counts the plugin's starts, so the result of a start
+	// that was disabled and enabled again can be told apart from the
+	// current one
+	generation int
+
+	// started is closed once the result of the plugin's latest start has
+	// been handled
+	started chan struct{}
+}
+
+func (rec *pluginRecord) setState(state PluginState, err error) {
+	rec.state = state
+	rec.err = err
+	rec.since = time.Now()
+}
+
+// tell sends the result of the plugin's current start to everyone waiting
+// for it
+func (rec *pluginRecord) tell(err error) {
+	for _, w := range rec.waiters {
+		w <- err
+	}
+	rec.waiters = nil
+}
+
+// PluginStatus returns the state of every plugin that has been added to the
+// bot, in the order they were added
+func (d *Deckard) PluginStatus() (status []PluginStatus) {
+	d.pluginsMu.RLock()
+	defer d.pluginsMu.RUnlock()
+	for _, rec := range d.records {
+		s := PluginStatus{
+			Name:  rec.plugin.Name(),
+			State: rec.state,
+			Since: rec.since,
+		}
+		if rec.err != nil {
+			s.Error = rec.err.Error()
+		}
+		status = append(status, s)
+	}
+	return
+}
+
+// record returns the record for the plugin with the name, ignoring case.
+// pluginsMu must be held.
+func (d *Deckard) record(name string) *pluginRecord {
+	for _, rec := range d.records {
+		if strings.EqualFold(rec.plugin.Name(), name) {
+			return rec
+		}
+	}
+	return nil
+}
+
+// available returns the names of the registered plugins that haven't been
+// added to the bot, so users can be told they exist. A plugin that was added
+// without the registry counts as added if its name matches.
+func (d *Deckard) available() (names []string) {
+	d.pluginsMu.RLock()
+	defer d.pluginsMu.RUnlock()
+	for _, name := range plugins.Registered() {
+		added := false
+		for _, rec := range d.records {
+			if rec.registeredAs == name || strings.EqualFold(rec.plugin.Name(), name) {
+				added = true
+				break
+			}
+		}
+		if !added {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// startPlugin marks the plugin as starting, gives it its brain, scheduler
+// and conversations and runs its OnInit in the background, once any earlier
+// start has finished. The result is handled by waitForPlugins and also sent
+// on the returned channel. pluginsMu must be held.
+func (d *Deckard) startPlugin(rec *pluginRecord) <-chan error {
+	result := make(chan error, 1)
+	rec.setState(PluginStarting, nil)
+	rec.waiters = append(rec.waiters, result)
+	rec.generation++
+	previous, done := rec.started, make(chan struct{})
+	rec.started = done
+	p, generation := rec.plugin, rec.generation
+	store := brain.Namespace(d.brain(), p.Name())
+	go func() {
+		if previous != nil {
+			// a plugin disabled and enabled again while starting
+			// mustn't run OnInit twice at once
+			select {
+			case <-previous:
+			case <-d.quitting():
+				d.abandonStart(rec, generation, done)
+				return
+			}
+		}
+		if bp, ok := p.(plugins.BrainPlugin); ok {
+			bp.SetBrain(store)
+		}
+		if sp, ok := p.(plugins.SchedulerPlugin); ok {
+			sp.SetScheduler(&pluginScheduler{d: d, plugin: p.Name()})
+		}
+		if cp, ok := p.(plugins.ConversationPlugin); ok {
+			cp.SetConversations(&pluginConversations{d: d, plugin: p})
+		}
+		select {
+		case d.pluginInitResult <- pluginResult{p, safeInitPlugin(p), generation, done}:
+		case <-d.quitting():
+			// the bot has stopped, so nobody will register it
+			shutdownPlugin(p)
+			d.abandonStart(rec, generation, done)
+		}
+	}()
+	return result
+}
+
+// abandonStart tells anyone waiting for a start that the bot stopped
+// before the plugin was registered
+func (d *Deckard) abandonStart(rec *pluginRecord, generation int, done chan struct{}) {
+	d.pluginsMu.Lock()
+	if rec.generation == generation {
+		rec.tell(errors.New("the bot stopped"))
+	}
+	d.pluginsMu.Unlock()
+	close(done)
+}
+
+// brain returns the bot's brain, keeping it in memory if the bot wasn't
+// given one. pluginsMu must be held.
+func (d *Deckard) brain() brain.Store {
+	if d.Brain == nil {
+		d.Brain = brain.NewMemory()
+	}
+	return d.Brain
+}
+
+// registerPlugin records the result of a plugin's OnInit and, if it
+// succeeded, starts sending it messages
+func (d *Deckard) registerPlugin(result pluginResult) {
+	fields := log.Fields{
+		"Plugin": result.Plugin.Name(),
+	}
+
+	defer close(result.done)
+
+	d.pluginsMu.Lock()
+	rec := d.record(result.Plugin.Name())
+	if rec == nil || rec.generation != result.Generation || rec.state != PluginStarting {
+		// Disabled while it was starting, so it shouldn't get any
+		// messages. If it was enabled again the next start waits for
+		// this, so shutting it down doesn't touch the new one.
+		d.pluginsMu.Unlock()
+		log.WithFields(fields).Info("Plugin was disabled while starting")
+		shutdownPlugin(result.Plugin)
+		return
+	}
+	if result.Error != nil {
+		rec.setState(PluginFailed, result.Error)
+	} else {
+		rec.setState(PluginRegistered, nil)
+		d.Plugins = append(d.Plugins, result.Plugin)
+	}
+	rec.tell(result.Error)
+	d.pluginsMu.Unlock()
+
+	if result.Error != nil {
+		fields["Error"] = result.Error.Error()
+		log.WithFields(fields).Warn("Plugin Registration Failed")
+		d.metrics().initErrors.Inc(result.Plugin.Name())
+	} else {
+		log.WithFields(fields).Info("Plugin Registered")
+	}
+}
+
+// enablePlugin starts a disabled, failed or quarantined plugin again
+func (d *Deckard) enablePlugin(name string) (<-chan error, error) {
+	d.pluginsMu.Lock()
+	defer d.pluginsMu.Unlock()
+	rec := d.record(name)
+	if rec == nil {
+		return nil, fmt.Errorf("there's no plugin named `%s`", name)
+	}
+	if rec.state == PluginRegistered || rec.state == PluginStarting {
+		return nil, fmt.Errorf("%s is already %s", rec.plugin.Name(), rec.state)
+	}
+	log.WithFields(log.Fields{"Plugin": rec.plugin.Name()}).Info("Enabling Plugin")
+	return d.startPlugin(rec), nil
+}
+
+// disablePlugin stops sending messages to a plugin and shuts it down
+func (d *Deckard) disablePlugin(name string) error {
+	return d.stopPlugin(name, PluginDisabled, nil)
+}
+
+// quarantinePlugin stops sending messages to a misbehaving plugin and shuts
+// it down. The reason is shown by !plugin list until it's enabled again.
+func (d *Deckard) quarantinePlugin(name string, reason error) error {
+	return d.stopPlugin(name, PluginQuarantined, reason)
+}
+
+// stopPlugin removes the plugin from the registered plugins and
+// shuts it down, leaving it in the given state
+func (d *Deckard) stopPlugin(name string, state PluginState, reason error) error {
+	d.pluginsMu.Lock()
+	rec := d.record(name)
+	if rec == nil {
+		d.pluginsMu.Unlock()
+		return fmt.Errorf("there's no plugin named `%s`", name)
+	}
+	if rec.state == state {
+		d.pluginsMu.Unlock()
+		return fmt.Errorf("%s is already %s", rec.plugin.Name(), state)
+	}
+	wasRegistered := rec.state == PluginRegistered
+	if rec.state == PluginStarting {
+		rec.tell(fmt.Errorf("it was %s while starting", state))
+	}
+	d.removePlugin(rec.plugin)
+	rec.setState(state, reason)
+	d.pluginsMu.Unlock()
+
+	log.WithFields(log.Fields{
+		"Plugin": rec.plugin.Name(),
+		"State":  string(state),
+	}).Info("Plugin Stopped")
+	if wasRegistered {
+		shutdownPlugin(rec.plugin)
+	}
+	return nil
+}
+
+// reloadPlugin shuts a plugin down and runs its OnInit again
+func (d *Deckard) reloadPlugin(name string) (<-chan error, error) {
+	d.pluginsMu.Lock()
+	rec := d.record(name)
+	if rec == nil {
+		d.pluginsMu.Unlock()
+		return nil, fmt.Errorf("there's no plugin named `%s`", name)
+	}
+	if rec.state == PluginStarting {
+		d.pluginsMu.Unlock()
+		return nil, fmt.Errorf("%s is still starting", rec.plugin.Name())
+	}
+	wasRegistered := rec.state == PluginRegistered
+	d.removePlugin(rec.plugin)
+	rec.setState(PluginDisabled, nil)
+	d.pluginsMu.Unlock()
+
+	if wasRegistered {
+		shutdownPlugin(rec.plugin)
+	}
+	log.WithFields(log.Fields{"Plugin": rec.plugin.Name()}).Info("Reloading Plugin")
+	return d.enablePlugin(name)
+}
+
+// removePlugin stops sending messages to the plugin. pluginsMu must be held.
+func (d *Deckard) removePlugin(p plugins.Plugin) {
+	for i, registered := range d.Plugins {
+		if registered == p {
+			d.Plugins = append(d.Plugins[:i:i], d.Plugins[i+1:]...)
+			return
+		}
+	}
+}
+
+// shutdownPlugins shuts down every registered plugin
+func (d *Deckard) shutdownPlugins() {
+	d.pluginsMu.RLock()
+	registered := append([]plugins.Plugin(nil), d.Plugins...)
+	d.pluginsMu.RUnlock()
+	for _, p := range registered {
+		shutdownPlugin(p)
+	}
+}
+
+// closeBrain closes the bot's brain once the plugins are done with it
+func (d *Deckard) closeBrain() {
+	d.pluginsMu.RLock()
+	defer d.pluginsMu.RUnlock()
+	if d.Brain == nil {
+		return
+	}
+	if err := d.Brain.Close(); err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not close the brain")
+	}
+}
+
+// shutdownPlugin calls the plugin's OnShutdown, if it has one
+func shutdownPlugin(p plugins.Plugin) {
+	sp, ok := p.(plugins.ShutdownPlugin)
+	if !ok {
+		return
+	}
+	if err := sp.OnShutdown(); err != nil {
+		log.WithFields(log.Fields{
+			"Plugin": p.Name(),
+			"Error":  err.Error(),
+		}).Warn("Plugin Shutdown Failed")
+	}
+}
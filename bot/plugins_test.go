@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/brain"
+)
+
+// flakyPlugin fails its first OnInit and counts its shutdowns
+type flakyPlugin struct {
+	testPlugin
+
+	mu        sync.Mutex
+	inits     int
+	shutdowns int
+}
+
+func (p *flakyPlugin) OnInit() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inits++
+	if p.inits == 1 {
+		return errors.New("service unavailable")
+	}
+	return nil
+}
+
+func (p *flakyPlugin) OnShutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shutdowns++
+	return nil
+}
+
+func waitForState(t *testing.T, d *Deckard, name string, want PluginState) PluginStatus {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range d.PluginStatus() {
+			if status.Name == name && status.State == want {
+				return status
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("plugin %s never reached state %s: %+v", name, want, d.PluginStatus())
+	return PluginStatus{}
+}
+
+func TestPluginLifecycle(t *testing.T) {
+	p := &flakyPlugin{testPlugin: testPlugin{name: "Flaky"}}
+	d, err := New("Test", newTestConnection(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.waitForPlugins(ctx)
+
+	status := waitForState(t, d, "Flaky", PluginFailed)
+	if status.Error != "service unavailable" {
+		t.Errorf("got error %q, want %q", status.Error, "service unavailable")
+	}
+	if len(d.matchingPlugins(testMessage)) != 0 {
+		t.Error("failed plugin was sent a message")
+	}
+
+	result, err := d.enablePlugin("flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("enable failed: %s", err)
+	}
+	waitForState(t, d, "Flaky", PluginRegistered)
+	if len(d.matchingPlugins(testMessage)) != 1 {
+		t.Error("enabled plugin wasn't sent a message")
+	}
+
+	if err := d.disablePlugin("Flaky"); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, d, "Flaky", PluginDisabled)
+	if len(d.matchingPlugins(testMessage)) != 0 {
+		t.Error("disabled plugin was sent a message")
+	}
+	if err := d.disablePlugin("Flaky"); err == nil {
+		t.Error("disabling a disabled plugin didn't fail")
+	}
+
+	result, err = d.reloadPlugin("Flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-result
+	waitForState(t, d, "Flaky", PluginRegistered)
+
+	result, err = d.reloadPlugin("Flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-result
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inits != 4 || p.shutdowns != 2 {
+		t.Errorf("got %d inits and %d shutdowns, want 4 and 2", p.inits, p.shutdowns)
+	}
+
+	if _, err := d.enablePlugin("Nope"); err == nil {
+		t.Error("enabling an unknown plugin didn't fail")
+	}
+}
+
+// brainPlugin counts its starts in its brain
+type brainPlugin struct {
+	testPlugin
+	brain brain.Store
+}
+
+func (p *brainPlugin) SetBrain(b brain.Store) { p.brain = b }
+
+func (p *brainPlugin) OnInit() error {
+	return p.brain.Update("starts", 0, func(old []byte) ([]byte, error) {
+		return append(old, '+'), nil
+	})
+}
+
+func TestPluginBrain(t *testing.T) {
+	store := brain.NewMemory()
+	d := &Deckard{Brain: store, pluginInitResult: make(chan pluginResult)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.waitForPlugins(ctx)
+
+	d.AddPlugin(&brainPlugin{testPlugin: testPlugin{name: "Counter"}})
+	waitForState(t, d, "Counter", PluginRegistered)
+	result, err := d.reloadPlugin("Counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-result
+
+	if v, err := store.Get("Counter/starts"); string(v) != "++" || err != nil {
+		t.Errorf("got %q, %v from the brain, want \"++\"", v, err)
+	}
+}
+
+// gatedPlugin's OnInit waits to be let go, and counts how many run at once
+type gatedPlugin struct {
+	testPlugin
+	release chan struct{}
+
+	mu        sync.Mutex
+	running   int
+	overlaps  int
+	shutdowns int
+}
+
+func (p *gatedPlugin) OnInit() error {
+	p.mu.Lock()
+	p.running++
+	if p.running > 1 {
+		p.overlaps++
+	}
+	p.mu.Unlock()
+	<-p.release
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *gatedPlugin) OnShutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shutdowns++
+	return nil
+}
+
+func TestPluginDisabledWhileStarting(t *testing.T) {
+	p := &gatedPlugin{testPlugin: testPlugin{name: "Slow"}, release: make(chan struct{})}
+	d := &Deckard{pluginInitResult: make(chan pluginResult)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.waitForPlugins(ctx)
+
+	d.AddPlugin(p)
+	d.pluginsMu.Lock()
+	first := d.records[0].waiters[0]
+	d.pluginsMu.Unlock()
+	if err := d.disablePlugin("Slow"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-first; err == nil {
+		t.Error("the first start succeeded after the plugin was disabled")
+	}
+	second, err := d.enablePlugin("Slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.release <- struct{}{}
+	p.release <- struct{}{}
+	if err := <-second; err != nil {
+		t.Fatalf("enable failed: %s", err)
+	}
+	waitForState(t, d, "Slow", PluginRegistered)
+	if len(d.matchingPlugins(testMessage)) != 1 {
+		t.Error("enabled plugin wasn't sent a message")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.overlaps != 0 || p.shutdowns != 1 {
+		t.Errorf("got %d overlapping inits and %d shutdowns, want 0 and 1", p.overlaps, p.shutdowns)
+	}
+}
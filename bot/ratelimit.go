@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+	"github.com/handwritingio/deckard-bot/ratelimit"
+)
+
+// RateLimits are token bucket limits checked before a message is sent to
+// any plugin. Messages that don't match a plugin don't count against them.
+type RateLimits struct {
+	// User limits how often each user may call plugins
+	User ratelimit.Limit
+
+	// Channel limits how often plugins may be called from each channel
+	Channel ratelimit.Limit
+
+	// Plugins limits how often each of a plugin's commands may be called by
+	// anyone. They're keyed by plugin name, which limits each of its commands
+	// separately, or by "Plugin:!command" for just that one. Messages that
+	// aren't for one of the commands the plugin lists share its plugin-wide
+	// bucket. They protect plugins that use a paid API or quota.
+	Plugins map[string]ratelimit.Limit
+}
+
+// limiters holds the buckets for the bot's RateLimits
+type limiters struct {
+	user, channel *ratelimit.Limiter
+
+	mu      sync.Mutex
+	plugins map[string]*ratelimit.Limiter // by plugin:command, or plugin
+}
+
+// rateLimiters returns the limiters for RateLimits, creating them the first
+// time they're needed
+func (d *Deckard) rateLimiters() *limiters {
+	d.limitersOnce.Do(func() {
+		d.limiters = &limiters{
+			user:    ratelimit.NewLimiter(d.RateLimits.User),
+			channel: ratelimit.NewLimiter(d.RateLimits.Channel),
+			plugins: make(map[string]*ratelimit.Limiter),
+		}
+	})
+	return d.limiters
+}
+
+// allowMessage checks the sender's and channel's rate limits. If either
+// is used up it tells the user to slow down and returns false.
+func (d *Deckard) allowMessage(in message.Basic, r plugins.Responder) bool {
+	l := d.rateLimiters()
+	if ok, wait := l.user.Allow(in.Connection + ":" + in.Sender.ID); !ok {
+		r.Send(message.Basic{Text: "Slow down, try again in " + formatWait(wait)})
+		return false
+	}
+	if in.Origin != "" {
+		if ok, wait := l.user.Allow(in.Connection + " from " + in.Origin); !ok {
+			r.Send(message.Basic{Text: "Slow down, try again in " + formatWait(wait)})
+			return false
+		}
+	}
+	if ok, wait := l.channel.Allow(in.Connection + ":" + in.Channel); !ok {
+		r.Send(message.Basic{Text: "Slow down, this channel is busy. Try again in " + formatWait(wait)})
+		return false
+	}
+	return true
+}
+
+// allowPlugin checks the rate limit of the plugin's command the message
+// calls. If it's used up it tells the user to slow down and returns false.
+func (d *Deckard) allowPlugin(p plugins.Plugin, in message.Basic, r plugins.Responder) bool {
+	command := commandName(p, in)
+	key := p.Name()
+	if command != "" {
+		key += ":" + command
+	}
+	l := d.rateLimiters()
+	l.mu.Lock()
+	limiter, ok := l.plugins[key]
+	if !ok {
+		limiter = ratelimit.NewLimiter(d.pluginLimit(p.Name(), command))
+		l.plugins[key] = limiter
+	}
+	l.mu.Unlock()
+
+	if ok, wait := limiter.Allow(key); !ok {
+		r.Send(message.Basic{Text: fmt.Sprintf("Slow down, plugin %s is busy. Try again in %s", p.Name(), formatWait(wait))})
+		return false
+	}
+	return true
+}
+
+// commandName returns the lower case name of the plugin's command that the
+// message calls, whatever case or alias was typed, or "" if it isn't one the
+// plugin lists
+func commandName(p plugins.Plugin, in message.Basic) string {
+	if cp, ok := p.(plugins.CommandPlugin); ok {
+		return strings.ToLower(cp.CommandName(in))
+	}
+	typed := commandOf(in)
+	for _, command := range p.Command() {
+		if strings.EqualFold(command, typed) {
+			return strings.ToLower(command)
+		}
+	}
+	return ""
+}
+
+// pluginLimit returns the limit for the plugin's command, matching names
+// without regard to case. A limit for the command beats one for the plugin.
+func (d *Deckard) pluginLimit(name, command string) ratelimit.Limit {
+	var limit ratelimit.Limit
+	for key, l := range d.RateLimits.Plugins {
+		switch {
+		case strings.EqualFold(key, name+":"+command):
+			return l
+		case strings.EqualFold(key, name):
+			limit = l
+		}
+	}
+	return limit
+}
+
+// formatWait rounds the wait up to whole seconds, e.g. "3 seconds"
+func formatWait(wait time.Duration) string {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs <= 1 {
+		return "1 second"
+	}
+	return fmt.Sprintf("%d seconds", secs)
+}
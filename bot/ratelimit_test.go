@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+	"github.com/handwritingio/deckard-bot/ratelimit"
+)
+
+func TestRateLimits(t *testing.T) {
+	d := &Deckard{
+		Plugins: []plugins.Plugin{
+			&testPlugin{name: "Cheap", reply: "cheap"},
+			&testPlugin{name: "Paid", reply: "paid"},
+		},
+		PluginTimeout: time.Second,
+		RateLimits: RateLimits{
+			User:    ratelimit.Limit{Burst: 3, Per: time.Hour},
+			Plugins: map[string]ratelimit.Limit{"paid": {Burst: 1, Per: time.Hour}},
+		},
+	}
+	sendFrom := func(origin, user, text string) string {
+		in := message.Basic{ID: 1, Text: text, Envelope: message.Envelope{Sender: message.User{ID: user}, Origin: origin}}
+		tx := make(message.BasicChannel)
+		go d.handleMessage(in, tx)
+		return strings.Join(collect(t, tx), "|")
+	}
+	send := func(user, text string) string { return sendFrom("", user, text) }
+
+	if got := send("alice", "!test"); got != "cheap|paid" {
+		t.Errorf("first message got %q", got)
+	}
+	if got := send("bob", "!test"); got != "Slow down, plugin Paid is busy. Try again in 3600 seconds|cheap" {
+		t.Errorf("plugin limit got %q", got)
+	}
+	// commands the plugin doesn't list share its plugin-wide bucket
+	if got := send("carol", "!testing"); got != "cheap|paid" {
+		t.Errorf("another command got %q, want the plugin's limit", got)
+	}
+	if got := send("dave", "!tested"); got != "Slow down, plugin Paid is busy. Try again in 3600 seconds|cheap" {
+		t.Errorf("a third command got %q, want the plugin's limit", got)
+	}
+	if got := commandName(&testPlugin{}, message.Basic{Text: "!TEST now"}); got != "!test" {
+		t.Errorf("command name = %q, want !test", got)
+	}
+	if got := send("alice", "chatter that no plugin matches"); got != "" {
+		t.Errorf("unmatched message got %q", got)
+	}
+	send("alice", "!test")
+	send("alice", "!test")
+	if got := send("alice", "!test"); !strings.HasPrefix(got, "Slow down, try again in") {
+		t.Errorf("user limit got %q", got)
+	}
+
+	// A client that names its own users can't escape the limit by
+	// changing the name
+	for _, user := range []string{"x1", "x2", "x3"} {
+		sendFrom("10.0.0.1", user, "!test")
+	}
+	if got := sendFrom("10.0.0.1", "x4", "!test"); !strings.HasPrefix(got, "Slow down, try again in") {
+		t.Errorf("origin limit got %q", got)
+	}
+
+	// Commands the sender isn't allowed to use don't count against them
+	d = &Deckard{
+		Plugins:       []plugins.Plugin{newDeployPlugin(), &testPlugin{name: "Cheap", reply: "cheap"}},
+		PluginTimeout: time.Second,
+		RateLimits:    RateLimits{User: ratelimit.Limit{Burst: 1, Per: time.Hour}},
+	}
+	send("bob", "!deploy start")
+	send("bob", "!deploy start")
+	if got := send("bob", "!test"); got != "cheap" {
+		t.Errorf("after refusals got %q, want cheap", got)
+	}
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"sync"
+
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// responder implements plugins.Responder for a single incoming message.
+// Every reply is tagged with the incoming message's ID before it goes to
+// the TX channel. It's the innermost Responder, after every middleware.
+// Replies sent once quit is closed are dropped, since nothing may be
+// reading the TX channel any more.
+type responder struct {
+	in   message.Basic
+	tx   message.BasicChannel
+	quit <-chan struct{}
+}
+
+func newResponder(in message.Basic, tx message.BasicChannel, quit <-chan struct{}) *responder {
+	return &responder{in: in, tx: tx, quit: quit}
+}
+
+// Send sends a reply to the incoming message. Blank replies are ignored.
+func (r *responder) Send(out message.Basic) {
+	if out.Text == "" && len(out.Blocks) > 0 {
+		out.Text = message.PlainText(out.Blocks)
+	}
+	if out.Text == "" {
+		return
+	}
+	out.ID = r.in.ID     // copy the id from the incoming message
+	out.Finished = false // only the bot decides when a message is finished
+
+	log.Infof("Incoming message: %#v", r.in)
+	log.Infof("Outgoing message: %#v", out)
+	select {
+	case r.tx <- out:
+	case <-r.quit:
+		log.Warnf("Dropping reply to message %d, the bot has stopped", r.in.ID)
+	}
+}
+
+// closableResponder passes a plugin's replies on to the next Responder
+// until it's closed, after which they're dropped
+type closableResponder struct {
+	in   message.Basic
+	next plugins.Responder
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newClosableResponder(in message.Basic, next plugins.Responder) *closableResponder {
+	return &closableResponder{in: in, next: next}
+}
+
+// Send passes the reply on, unless the responder has been closed
+func (r *closableResponder) Send(out message.Basic) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		log.Debugf("Dropping reply to message %d, the plugin has already timed out", r.in.ID)
+		return
+	}
+	r.next.Send(out)
+}
+
+// close stops any further replies from being sent
+func (r *closableResponder) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+}
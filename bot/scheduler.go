@@ -0,0 +1,408 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+
+	"github.com/robfig/cron"
+)
+
+// Clock tells the time. The scheduler uses it, so tests can control time.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock used when the bot isn't given one
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// schedulerNamespace is where jobs are kept in the brain
+const schedulerNamespace = "_scheduler"
+
+// jobRetryDelay is how long a job is put off for if its plugin isn't
+// registered when it's due, e.g. because it's still starting
+const jobRetryDelay = time.Minute
+
+// scheduledJob is a plugin's job, as it's kept in the brain
+type scheduledJob struct {
+	Plugin string
+	Job    plugins.Job
+}
+
+func (j *scheduledJob) key() string {
+	return j.Plugin + "/" + j.Job.ID
+}
+
+// scheduler holds every plugin's jobs
+type scheduler struct {
+	store brain.Store
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob // by key
+
+	// wake tells the scheduler loop that the jobs have changed
+	wake chan struct{}
+}
+
+// pluginScheduler is the plugins.Scheduler given to a plugin
+type pluginScheduler struct {
+	d      *Deckard
+	plugin string
+}
+
+func (ps *pluginScheduler) Schedule(job plugins.Job) (plugins.Job, error) {
+	return ps.d.schedule(ps.plugin, job)
+}
+
+func (ps *pluginScheduler) Cancel(id string) error {
+	return ps.d.cancelJob(ps.plugin, id)
+}
+
+func (ps *pluginScheduler) Jobs() []plugins.Job {
+	return ps.d.jobs(ps.plugin)
+}
+
+// clock returns the bot's Clock
+func (d *Deckard) clock() Clock {
+	if d.Clock == nil {
+		return realClock{}
+	}
+	return d.Clock
+}
+
+// scheduler returns the bot's scheduler, loading the jobs from the brain
+// the first time it's needed
+func (d *Deckard) scheduler() *scheduler {
+	d.schedulerOnce.Do(func() {
+		d.pluginsMu.Lock()
+		store := brain.Namespace(d.brain(), schedulerNamespace)
+		d.pluginsMu.Unlock()
+
+		s := &scheduler{
+			store: store,
+			jobs:  make(map[string]*scheduledJob),
+			wake:  make(chan struct{}, 1),
+		}
+		err := store.Scan("", func(key string, value []byte) error {
+			var j scheduledJob
+			if err := json.Unmarshal(value, &j); err != nil {
+				log.Warnf("Ignoring scheduled job %s: %s", key, err.Error())
+				return nil
+			}
+			s.jobs[key] = &j
+			return nil
+		})
+		if err != nil {
+			log.Warnf("Could not load scheduled jobs: %s", err.Error())
+		}
+		d.sched = s
+	})
+	return d.sched
+}
+
+// save writes the job to the brain. s.mu must be held.
+func (s *scheduler) save(j *scheduledJob) {
+	data, err := json.Marshal(j)
+	if err == nil {
+		err = s.store.Set(j.key(), data, 0)
+	}
+	if err != nil {
+		log.Warnf("Could not save scheduled job %s: %s", j.key(), err.Error())
+	}
+}
+
+// remove deletes the job. s.mu must be held.
+func (s *scheduler) remove(j *scheduledJob) {
+	delete(s.jobs, j.key())
+	if err := s.store.Delete(j.key()); err != nil {
+		log.Warnf("Could not delete scheduled job %s: %s", j.key(), err.Error())
+	}
+}
+
+// poke wakes the scheduler loop so it sees changed jobs
+func (s *scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule validates and adds a plugin's job
+func (d *Deckard) schedule(plugin string, job plugins.Job) (plugins.Job, error) {
+	set := 0
+	for _, isSet := range []bool{job.Cron != "", job.Every != 0, !job.At.IsZero()} {
+		if isSet {
+			set++
+		}
+	}
+	switch {
+	case set != 1:
+		return job, errors.New("a job needs exactly one of Cron, Every or At")
+	case job.Channel == "":
+		return job, errors.New("a job needs a Channel to post to")
+	case job.Every < 0 || (job.Every > 0 && job.Every < time.Second):
+		return job, errors.New("a job can't run more than once a second")
+	}
+
+	if job.ID == "" {
+		job.ID = newJobID()
+	}
+	next, err := nextRun(job, d.clock().Now())
+	if err != nil {
+		return job, err
+	}
+	job.Next = next
+
+	s := d.scheduler()
+	s.mu.Lock()
+	j := &scheduledJob{Plugin: plugin, Job: job}
+	s.jobs[j.key()] = j
+	s.save(j)
+	s.mu.Unlock()
+	s.poke()
+
+	log.WithFields(log.Fields{
+		"Plugin": plugin,
+		"Job":    job.ID,
+		"Next":   job.Next.String(),
+	}).Info("Job Scheduled")
+	return job, nil
+}
+
+// cancelJob removes a plugin's job
+func (d *Deckard) cancelJob(plugin, id string) error {
+	s := d.scheduler()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[(&scheduledJob{Plugin: plugin, Job: plugins.Job{ID: id}}).key()]
+	if !ok {
+		return fmt.Errorf("there's no job %s", id)
+	}
+	s.remove(j)
+	return nil
+}
+
+// jobs returns a plugin's jobs, ordered by when they next run
+func (d *Deckard) jobs(plugin string) (jobs []plugins.Job) {
+	s := d.scheduler()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Plugin == plugin {
+			jobs = append(jobs, j.Job)
+		}
+	}
+	sort.Sort(jobsByNext(jobs))
+	return
+}
+
+// jobsByNext sorts jobs by when they next run
+type jobsByNext []plugins.Job
+
+func (j jobsByNext) Len() int           { return len(j) }
+func (j jobsByNext) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+func (j jobsByNext) Less(a, b int) bool { return j[a].Next.Before(j[b].Next) }
+
+// nextRun returns when the job should first run after the time
+func nextRun(job plugins.Job, after time.Time) (time.Time, error) {
+	switch {
+	case job.Cron != "":
+		schedule, err := cron.ParseStandard(job.Cron)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid cron schedule %q: %s", job.Cron, err.Error())
+		}
+		if job.TimeZone != "" {
+			loc, err := time.LoadLocation(job.TimeZone)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("unknown time zone %q", job.TimeZone)
+			}
+			after = after.In(loc)
+		}
+		return schedule.Next(after), nil
+	case job.Every > 0:
+		return after.Add(job.Every), nil
+	default:
+		return job.At, nil
+	}
+}
+
+// newJobID returns a random ID for a job
+func newJobID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// runScheduler runs jobs as they come due until ctx is done
+func (d *Deckard) runScheduler(ctx context.Context) {
+	s := d.scheduler()
+	clock := d.clock()
+	for {
+		now := clock.Now()
+		due, next := d.dueJobs(now)
+		for _, j := range due {
+			d.inflight.Add(1)
+			go func(j scheduledJob) {
+				defer d.inflight.Done()
+				d.safeRunJob(j)
+			}(j)
+		}
+
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = clock.After(next.Sub(now))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer:
+		case <-s.wake:
+		}
+	}
+}
+
+// dueJobs returns the jobs that should run now, having worked out when they
+// run next, and when the next job is due after that
+func (d *Deckard) dueJobs(now time.Time) (due []scheduledJob, next time.Time) {
+	s := d.scheduler()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Job.Next.After(now) {
+			next = earliest(next, j.Job.Next)
+			continue
+		}
+		if d.registeredPlugin(j.Plugin) == nil {
+			j.Job.Next = now.Add(jobRetryDelay)
+			s.save(j)
+			next = earliest(next, j.Job.Next)
+			continue
+		}
+
+		due = append(due, *j)
+		if !j.Job.At.IsZero() {
+			s.remove(j)
+			continue
+		}
+		runAfter := now
+		if j.Job.Every > 0 && j.Job.Next.Add(j.Job.Every).After(now) {
+			// keep to the original schedule rather than drifting
+			runAfter = j.Job.Next
+		}
+		n, err := nextRun(j.Job, runAfter)
+		if err != nil {
+			log.Warnf("Removing scheduled job %s: %s", j.key(), err.Error())
+			s.remove(j)
+			continue
+		}
+		j.Job.Next = n
+		s.save(j)
+		next = earliest(next, n)
+	}
+	return
+}
+
+// earliest returns the earlier of the times, ignoring a zero time
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// registeredPlugin returns the registered plugin with the name, or nil
+func (d *Deckard) registeredPlugin(name string) plugins.Plugin {
+	d.pluginsMu.RLock()
+	defer d.pluginsMu.RUnlock()
+	for _, p := range d.Plugins {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// poster returns the connection with the name if it can post, or else the
+// first connection that can
+func (d *Deckard) poster(name string) (*attachedConnection, connection.Poster, error) {
+	var fallback *attachedConnection
+	for _, ac := range d.conns {
+		if _, ok := ac.conn.(connection.Poster); !ok {
+			continue
+		}
+		if ac.name == name {
+			return ac, ac.conn.(connection.Poster), nil
+		}
+		if fallback == nil {
+			fallback = ac
+		}
+	}
+	if fallback == nil {
+		return nil, nil, errors.New("none of the bot's connections can post messages")
+	}
+	return fallback, fallback.conn.(connection.Poster), nil
+}
+
+// runJob posts the job's Text, or whatever its plugin's HandleJob sends,
+// to the job's channel. The job goes through the middleware and is timed
+// out and audited like a message sent to the plugin.
+func (d *Deckard) runJob(p plugins.Plugin, j scheduledJob) {
+	fields := log.Fields{
+		"Plugin":  j.Plugin,
+		"Job":     j.Job.ID,
+		"Channel": j.Job.Channel,
+	}
+	ac, poster, err := d.poster(j.Job.Connection)
+	if err != nil {
+		fields["Error"] = err.Error()
+		log.WithFields(fields).Warn("Could not run job")
+		return
+	}
+	log.WithFields(fields).Info("Running Job")
+
+	r := ResponderFunc(func(out message.Basic) {
+		if out.Text == "" && len(out.Blocks) > 0 {
+			out.Text = message.PlainText(out.Blocks)
+		}
+		if out.Text == "" {
+			return
+		}
+		if err := poster.Post(j.Job.Channel, out.Text); err != nil {
+			fields["Error"] = err.Error()
+			log.WithFields(fields).Warn("Could not post job's message")
+			return
+		}
+		d.metrics().sent.Inc(ac.label())
+	})
+	handle := func(in message.Basic, r plugins.Responder) {
+		if jp, ok := p.(plugins.JobPlugin); ok {
+			jp.HandleJob(j.Job, r)
+			return
+		}
+		r.Send(message.Basic{Text: j.Job.Text})
+	}
+
+	in := message.Basic{Text: "scheduled job " + j.Job.ID}
+	in.Channel = j.Job.Channel
+	in.Connection = ac.label()
+	in.Timestamp = d.clock().Now()
+	d.wrap(func(in message.Basic, r plugins.Responder) {
+		d.callPlugin(p, in, r, handle)
+	})(in, r)
+}
@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/audit"
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// fakeClock only moves when it's advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t.c
+	}
+	c.waiters = append(c.waiters, t)
+	return t.c
+}
+
+// Advance moves the clock on, firing any timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, t := range c.waiters {
+		if t.at.After(c.now) {
+			waiting = append(waiting, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// postingConnection is a testConnection that can post messages
+type postingConnection struct {
+	*testConnection
+	posts chan string
+}
+
+func (c *postingConnection) Post(channel, text string) error {
+	c.posts <- channel + ": " + text
+	return nil
+}
+
+// schedulingPlugin keeps the scheduler it's given
+type schedulingPlugin struct {
+	testPlugin
+	mu        sync.Mutex
+	scheduler plugins.Scheduler
+}
+
+func (p *schedulingPlugin) SetScheduler(s plugins.Scheduler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scheduler = s
+}
+
+func TestScheduler(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2016, 9, 30, 8, 0, 0, 0, time.Local)} // a Friday
+	conn := &postingConnection{newTestConnection(), make(chan string)}
+	p := &schedulingPlugin{testPlugin: testPlugin{name: "Sched"}}
+	store := brain.NewMemory()
+	d := &Deckard{Brain: store, Clock: clock, pluginInitResult: make(chan pluginResult)}
+	d.AddConnection("", conn)
+	d.AddPlugin(p)
+	var mu sync.Mutex
+	var seen []string
+	d.Use(func(next Handler) Handler {
+		return func(in message.Basic, r plugins.Responder) {
+			mu.Lock()
+			seen = append(seen, in.Text)
+			mu.Unlock()
+			next(in, r)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	waitForState(t, d, "Sched", PluginRegistered)
+
+	p.mu.Lock()
+	s := p.scheduler
+	p.mu.Unlock()
+	for _, job := range []plugins.Job{
+		{ID: "standup", Channel: "C1", Text: "standup", Cron: "0 9 * * 1-5"},
+		{Channel: "C1", Text: "ping", Every: 30 * time.Minute},
+		{Channel: "C2", Text: "once", At: clock.Now().Add(10 * time.Minute)},
+	} {
+		if _, err := s.Schedule(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Schedule(plugins.Job{Channel: "C1", Every: time.Hour, Cron: "@daily"}); err == nil {
+		t.Error("scheduling a job with two schedules didn't fail")
+	}
+
+	expect := func(want ...string) {
+		var got []string
+		for range want {
+			select {
+			case post := <-conn.posts:
+				got = append(got, post)
+			case <-time.After(time.Second):
+				t.Fatalf("got posts %q, want %q", got, want)
+			}
+		}
+		sort.Strings(got)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("got posts %q, want %q", got, want)
+			}
+		}
+	}
+	clock.Advance(10 * time.Minute)
+	expect("C2: once")
+	clock.Advance(20 * time.Minute)
+	expect("C1: ping")
+	clock.Advance(30 * time.Minute)
+	expect("C1: ping", "C1: standup")
+
+	// Jobs go through the middleware and into the audit log
+	mu.Lock()
+	sort.Strings(seen)
+	if len(seen) != 4 || seen[3] != "scheduled job standup" {
+		t.Errorf("middleware saw %q", seen)
+	}
+	mu.Unlock()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		entries, err := d.auditLog().Query(audit.Query{Plugin: "Sched"})
+		if err == nil && len(entries) == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("audit log has %d jobs, want 4", len(entries))
+			break
+		}
+	}
+
+	jobs := s.Jobs()
+	if len(jobs) != 2 || jobs[0].Next.Hour() != 9 || jobs[0].Next.Minute() != 30 {
+		t.Errorf("got jobs %+v", jobs)
+	}
+	if err := s.Cancel("standup"); err != nil {
+		t.Error(err)
+	}
+
+	// The remaining job is kept in the brain for the next bot
+	restarted := &Deckard{Brain: store}
+	if jobs := restarted.jobs("Sched"); len(jobs) != 1 || jobs[0].Text != "ping" {
+		t.Errorf("restarted bot got jobs %+v", jobs)
+	}
+}
+
+func TestNextRunTimeZone(t *testing.T) {
+	after := time.Date(2016, 9, 30, 8, 0, 0, 0, time.UTC)
+	job := plugins.Job{Cron: "0 9 * * *", TimeZone: "America/New_York"}
+	if next, err := nextRun(job, after); err != nil || !next.Equal(time.Date(2016, 9, 30, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("next run = %s, %v, want 9am in New York", next, err)
+	}
+	job.TimeZone = "Mars/Olympus_Mons"
+	if _, err := nextRun(job, after); err == nil {
+		t.Error("an unknown time zone didn't fail")
+	}
+}
@@ -0,0 +1,165 @@
+package brain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucketName is the bolt bucket every key is kept in
+var bucketName = []byte("brain")
+
+// Bolt is a Store kept in a single file on disk, so it survives restarts
+type Bolt struct {
+	// Now returns the current time, and can be replaced in tests
+	Now func() time.Time
+
+	db *bolt.DB
+}
+
+// Open opens the Store in the file at path, creating it if it doesn't exist.
+// Only one process can have the file open at a time.
+func Open(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{Now: time.Now, db: db}, nil
+}
+
+// Values are stored with their expiry in front, as nanoseconds since the
+// Unix epoch or zero if they don't expire
+const expiryLen = 8
+
+func encodeValue(value []byte, expires time.Time) []byte {
+	b := make([]byte, expiryLen+len(value))
+	if !expires.IsZero() {
+		binary.BigEndian.PutUint64(b, uint64(expires.UnixNano()))
+	}
+	copy(b[expiryLen:], value)
+	return b
+}
+
+// decodeValue returns a copy of the stored value, or false if it has expired
+func (b *Bolt) decodeValue(stored []byte) ([]byte, bool) {
+	if len(stored) < expiryLen {
+		return nil, false
+	}
+	var expires time.Time
+	if ns := binary.BigEndian.Uint64(stored); ns != 0 {
+		expires = time.Unix(0, int64(ns))
+	}
+	if expired(b.Now(), expires) {
+		return nil, false
+	}
+	return append([]byte{}, stored[expiryLen:]...), true
+}
+
+// Get returns the key's value, or ErrNotFound
+func (b *Bolt) Get(key string) (value []byte, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		var ok bool
+		value, ok = b.decodeValue(tx.Bucket(bucketName).Get([]byte(key)))
+		if !ok {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return
+}
+
+// Set sets the key's value, expiring it after ttl if that's greater than zero
+func (b *Bolt) Set(key string, value []byte, ttl time.Duration) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte(key), encodeValue(value, expiry(b.Now(), ttl)))
+	})
+}
+
+// Delete removes the key
+func (b *Bolt) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(key))
+	})
+}
+
+// Update atomically replaces the key's value with the value returned by fn
+func (b *Bolt) Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		old, _ := b.decodeValue(bucket.Get([]byte(key)))
+		value, err := fn(old)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return bucket.Delete([]byte(key))
+		}
+		return bucket.Put([]byte(key), encodeValue(value, expiry(b.Now(), ttl)))
+	})
+}
+
+// Scan calls fn for every key starting with prefix, in key order. Expired
+// keys are skipped and removed.
+func (b *Bolt) Scan(prefix string, fn func(key string, value []byte) error) error {
+	var keys []string
+	var values [][]byte
+	var expiredKeys [][]byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			value, ok := b.decodeValue(v)
+			if !ok {
+				expiredKeys = append(expiredKeys, append([]byte{}, k...))
+				continue
+			}
+			keys = append(keys, string(k))
+			values = append(values, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(expiredKeys) > 0 {
+		err = b.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(bucketName)
+			for _, k := range expiredKeys {
+				if _, ok := b.decodeValue(bucket.Get(k)); ok {
+					continue // set again since we looked
+				}
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// fn is called outside the transaction so it can modify the store
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
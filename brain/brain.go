@@ -0,0 +1,107 @@
+/*
+Package brain is the bot's memory, a key/value store that plugins can use to
+keep state across restarts, e.g. karma scores, reminders or quotes.
+
+Each plugin is given its own namespace of the bot's Store, so plugins can't
+see or overwrite each other's keys. Values are bytes; encode them however suits
+the plugin, e.g. with encoding/json.
+
+There are two backends. NewMemory keeps everything in memory and is handy for
+tests, and Open keeps everything in a single file on disk.
+
+ karma := 0
+ err := store.Update("karma/alice", 0, func(old []byte) ([]byte, error) {
+ 	if old != nil {
+ 		karma, _ = strconv.Atoi(string(old))
+ 	}
+ 	karma++
+ 	return []byte(strconv.Itoa(karma)), nil
+ })
+*/
+package brain
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Get when the key doesn't exist or has expired
+var ErrNotFound = errors.New("brain: key not found")
+
+// Store is a key/value store. It's safe for concurrent use.
+type Store interface {
+	// Get returns the key's value, or ErrNotFound
+	Get(key string) ([]byte, error)
+
+	// Set sets the key's value. If ttl is greater than zero the key expires
+	// after that long.
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete removes the key. Deleting a key that doesn't exist isn't an error.
+	Delete(key string) error
+
+	// Update atomically replaces the key's value with the value returned by fn,
+	// which is passed the current value or nil if there isn't one. If fn returns
+	// nil the key is deleted, and if it returns an error the key is left alone
+	// and Update returns the error. The ttl is applied like Set.
+	Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error
+
+	// Scan calls fn for every key starting with prefix, in key order, and
+	// stops at the first error fn returns. fn may modify the store.
+	Scan(prefix string, fn func(key string, value []byte) error) error
+
+	// Close releases the store's resources
+	Close() error
+}
+
+// namespace is a Store whose keys are all prefixed
+type namespace struct {
+	store  Store
+	prefix string
+}
+
+// Namespace returns a view of the store where every key is prefixed
+// with name and a slash. Closing it doesn't close the underlying store.
+func Namespace(store Store, name string) Store {
+	return &namespace{store: store, prefix: name + "/"}
+}
+
+func (n *namespace) Get(key string) ([]byte, error) {
+	return n.store.Get(n.prefix + key)
+}
+
+func (n *namespace) Set(key string, value []byte, ttl time.Duration) error {
+	return n.store.Set(n.prefix+key, value, ttl)
+}
+
+func (n *namespace) Delete(key string) error {
+	return n.store.Delete(n.prefix + key)
+}
+
+func (n *namespace) Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	return n.store.Update(n.prefix+key, ttl, fn)
+}
+
+func (n *namespace) Scan(prefix string, fn func(key string, value []byte) error) error {
+	return n.store.Scan(n.prefix+prefix, func(key string, value []byte) error {
+		return fn(key[len(n.prefix):], value)
+	})
+}
+
+func (n *namespace) Close() error {
+	return nil
+}
+
+// expiry returns when a key set now with the ttl expires,
+// or the zero time if it doesn't
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// expired reports whether a key with the expiry has expired
+func expired(now, expires time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
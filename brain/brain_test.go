@@ -0,0 +1,107 @@
+package brain
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ExampleNamespace() {
+	store := NewMemory()
+	karma := Namespace(store, "Karma")
+	quotes := Namespace(store, "Quotes")
+
+	karma.Set("alice", []byte("3"), 0)
+	quotes.Set("alice", []byte("Ship it"), 0)
+
+	karma.Scan("", func(key string, value []byte) error {
+		fmt.Printf("karma %s=%s\n", key, value)
+		return nil
+	})
+	store.Scan("", func(key string, value []byte) error {
+		fmt.Printf("%s=%s\n", key, value)
+		return nil
+	})
+	// Output:
+	// karma alice=3
+	// Karma/alice=3
+	// Quotes/alice=Ship it
+}
+
+func TestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	memory := NewMemory()
+	bolt, err := Open(filepath.Join(dir, "brain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	for name, s := range map[string]struct {
+		store Store
+		now   *func() time.Time
+	}{
+		"memory": {memory, &memory.Now},
+		"bolt":   {bolt, &bolt.Now},
+	} {
+		now := time.Date(2016, 10, 1, 9, 0, 0, 0, time.UTC)
+		*s.now = func() time.Time { return now }
+		store := s.store
+
+		if _, err := store.Get("missing"); err != ErrNotFound {
+			t.Errorf("%s: Get missing key returned %v", name, err)
+		}
+
+		store.Set("a/1", []byte("one"), 0)
+		store.Set("a/2", []byte("two"), time.Minute)
+		store.Set("b/1", []byte("other"), 0)
+
+		increment := func(old []byte) ([]byte, error) {
+			return append(old, '+'), nil
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.Update("a/0", 0, increment); err != nil {
+				t.Fatalf("%s: Update failed: %s", name, err)
+			}
+		}
+		boom := errors.New("boom")
+		if err := store.Update("a/0", 0, func([]byte) ([]byte, error) { return nil, boom }); err != boom {
+			t.Errorf("%s: Update returned %v, want %v", name, err, boom)
+		}
+
+		scan := func() string {
+			var s []string
+			store.Scan("a/", func(key string, value []byte) error {
+				s = append(s, key+"="+string(value))
+				return nil
+			})
+			return strings.Join(s, " ")
+		}
+		if got, want := scan(), "a/0=++ a/1=one a/2=two"; got != want {
+			t.Errorf("%s: Scan got %q, want %q", name, got, want)
+		}
+
+		now = now.Add(time.Minute)
+		if _, err := store.Get("a/2"); err != ErrNotFound {
+			t.Errorf("%s: expired key returned %v", name, err)
+		}
+		store.Update("a/0", 0, func([]byte) ([]byte, error) { return nil, nil })
+		store.Delete("a/1")
+		if got := scan(); got != "" {
+			t.Errorf("%s: Scan after deleting got %q", name, got)
+		}
+		if v, err := store.Get("b/1"); string(v) != "other" || err != nil {
+			t.Errorf("%s: Get got %q, %v", name, v, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package brain
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps everything in memory, so it's forgotten
+// when the bot stops
+type Memory struct {
+	// Now returns the current time, and can be replaced in tests
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory creates an empty in-memory Store
+func NewMemory() *Memory {
+	return &Memory{
+		Now:     time.Now,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+// get returns the key's value if it hasn't expired. m.mu must be held.
+func (m *Memory) get(key string) ([]byte, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if expired(m.Now(), e.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// set copies the value into the store. m.mu must be held.
+func (m *Memory) set(key string, value []byte, ttl time.Duration) {
+	m.entries[key] = memoryEntry{
+		value:   append([]byte(nil), value...),
+		expires: expiry(m.Now(), ttl),
+	}
+}
+
+// Get returns the key's value, or ErrNotFound
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Set sets the key's value, expiring it after ttl if that's greater than zero
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value, ttl)
+	return nil
+}
+
+// Delete removes the key
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// Update atomically replaces the key's value with the value returned by fn
+func (m *Memory) Update(key string, ttl time.Duration, fn func(old []byte) ([]byte, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.get(key)
+	if ok {
+		old = append([]byte(nil), old...)
+	}
+	value, err := fn(old)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		delete(m.entries, key)
+		return nil
+	}
+	m.set(key, value, ttl)
+	return nil
+}
+
+// Scan calls fn for every key starting with prefix, in key order
+func (m *Memory) Scan(prefix string, fn func(key string, value []byte) error) error {
+	m.mu.Lock()
+	var keys []string
+	values := make(map[string][]byte)
+	for key := range m.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if value, ok := m.get(key); ok {
+			keys = append(keys, key)
+			values[key] = append([]byte(nil), value...)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing, since there's nothing to release
+func (m *Memory) Close() error {
+	return nil
+}
@@ -1,8 +1,12 @@
 // Package config provides access to application-wide configuration.
-// All configuration should be via environment variables. See http://12factor.net/config.
+// The bot is described by a configuration File, and every setting can be
+// overridden by an environment variable. See http://12factor.net/config.
 package config
 
-import "os"
+import (
+	"os"
+	"strings"
+)
 
 var (
 	// SlackAPIURL is the Slack API URL
@@ -26,3 +30,31 @@ func getEnvDefault(key string, defaultValue string) string {
 	}
 	return v
 }
+
+// splitList splits a comma separated list, dropping blanks
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+// parseMap parses "name=a,b;other=c" into a list of values by name
+func parseMap(s string) map[string][]string {
+	m := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(entry[:i])
+		for _, v := range strings.Split(entry[i+1:], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				m[name] = append(m[name], v)
+			}
+		}
+	}
+	return m
+}
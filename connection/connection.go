@@ -7,12 +7,70 @@
 //
 // Messages sent from the chatbot from the plugins are sent into the tx channel. The chatbot takes messages
 // from the tx channel and returns it to the connection interface.
+//
+// When the chatbot shuts down it stops reading from the rx channel, waits for
+// in-flight replies on the tx channel and then calls Close.
 package connection
 
-import "github.com/handwritingio/deckard-bot/message"
+import (
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
 
 // Connection interface has a Start method for creating the connection
-// two basic channels for transmitting and receiving messages
+// two basic channels for transmitting and receiving messages, and a Close
+// method for tearing it down again
 type Connection interface {
 	Start(chan error) (rx, tx message.BasicChannel)
+
+	// Close stops the connection's goroutines and releases anything it holds
+	// open. Nothing is sent on the error channel after Close is called.
+	Close() error
+}
+
+// Poster is a Connection that can post a message to a channel on its own,
+// rather than in reply to a message, e.g. for scheduled messages
+type Poster interface {
+	Connection
+
+	// Post sends the text to the channel. It blocks until the message is sent
+	// or the connection is closed.
+	Post(channel, text string) error
+}
+
+// Status is what a connection knows about how it's doing
+type Status struct {
+	// Connected is true while the connection is linked to the service it
+	// talks to, e.g. while Slack's websocket is open
+	Connected bool
+
+	// GaveUp is true once the connection has stopped trying to reconnect,
+	// e.g. because too many attempts failed in a row
+	GaveUp bool
+
+	// LastEvent is when the connection last received anything from the
+	// service. It's zero if it hasn't yet.
+	LastEvent time.Time
+
+	// LastPong is when the service last answered the connection's keepalive.
+	// It's zero if it hasn't yet, or the connection has no keepalive.
+	LastPong time.Time
+
+	// Inbox is how many received messages are still waiting for the bot
+	// to finish replying
+	Inbox int
+
+	// Reconnects is how many times the connection has had to reconnect
+	Reconnects int
+}
+
+// StatusReporter is a Connection that can report its Status, which the bot
+// exposes as metrics and in its health checks
+type StatusReporter interface {
+	Connection
+
+	// Status returns the connection's current status. It's safe to call
+	// from any goroutine.
+	Status() Status
 }
@@ -0,0 +1,69 @@
+package connection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/handwritingio/deckard-bot/config"
+)
+
+// Factory creates a connection from the settings in its section of the
+// configuration file. It should return an error if the settings are wrong,
+// e.g. by decoding them with settings.Decode.
+type Factory func(settings config.Section) (Connection, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a type of connection available by name, so it can be used
+// in the configuration file. Connection packages call it from init, so
+// importing the package is enough to make the connection available:
+//
+//	func init() {
+//		connection.Register("irc", func(settings config.Section) (connection.Connection, error) {
+//			c := &Connection{}
+//			return c, settings.Decode(c)
+//		})
+//	}
+//
+// Names are lowercase, and Register panics if the name is taken.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	name = strings.ToLower(name)
+	if factory == nil {
+		panic("connection: Register factory for " + name + " is nil")
+	}
+	if _, taken := factories[name]; taken {
+		panic("connection: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Registered returns the names of every registered type of connection,
+// sorted
+func Registered() (names []string) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// New creates a connection of the registered type with the name, ignoring
+// case, from its settings
+func New(name string, settings config.Section) (Connection, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[strings.ToLower(name)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%q isn't a kind of connection, try one of %s", name, strings.Join(Registered(), ", "))
+	}
+	return factory(settings)
+}
@@ -0,0 +1,36 @@
+package connection_test
+
+import (
+	"fmt"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+type loopback struct {
+	Prefix string `yaml:"prefix"`
+}
+
+func (l *loopback) Start(chan error) (rx, tx message.BasicChannel) { return nil, nil }
+func (l *loopback) Close() error                                   { return nil }
+
+func ExampleRegister() {
+	connection.Register("loopback", func(settings config.Section) (connection.Connection, error) {
+		l := &loopback{Prefix: "> "}
+		return l, settings.Decode(l)
+	})
+
+	c, err := connection.New("Loopback", config.Section{"prefix": "$ "})
+	fmt.Printf("%q %v\n", c.(*loopback).Prefix, err)
+
+	_, err = connection.New("loopback", config.Section{"prefx": "$ "})
+	fmt.Println(err != nil)
+
+	_, err = connection.New("irc", nil)
+	fmt.Println(err)
+	// Output:
+	// "$ " <nil>
+	// true
+	// "irc" isn't a kind of connection, try one of loopback
+}
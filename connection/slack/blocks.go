@@ -0,0 +1,91 @@
+package slack
+
+import "github.com/handwritingio/deckard-bot/message"
+
+// block is a Slack Block Kit layout block. See
+// https://api.slack.com/reference/block-kit/blocks
+type block struct {
+	Type     string  `json:"type"`
+	Text     *text   `json:"text,omitempty"`
+	Fields   []*text `json:"fields,omitempty"`
+	ImageURL string  `json:"image_url,omitempty"`
+	AltText  string  `json:"alt_text,omitempty"`
+	Title    *text   `json:"title,omitempty"`
+	URL      string  `json:"url,omitempty"`
+
+	// Elements are the buttons of an actions block, or the text of a
+	// context block
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+// text is a Block Kit text object
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func mrkdwn(s string) *text {
+	return &text{Type: "mrkdwn", Text: s}
+}
+
+func plainText(s string) *text {
+	return &text{Type: "plain_text", Text: s}
+}
+
+// renderBlocks turns a rich reply's blocks into Block Kit blocks. Blocks
+// Slack has nothing like are shown as their PlainText.
+func renderBlocks(blocks []message.Block) []block {
+	out := []block{}
+	for _, b := range blocks {
+		switch b := b.(type) {
+		case message.Section:
+			section := block{Type: "section"}
+			if b.Text != "" {
+				section.Text = mrkdwn(b.Text)
+			}
+			for _, f := range b.Fields {
+				section.Fields = append(section.Fields, mrkdwn("*"+f.Title+"*\n"+f.Value))
+			}
+			if section.Text == nil && len(section.Fields) == 0 {
+				continue
+			}
+			out = append(out, section)
+		case message.Image:
+			image := block{Type: "image", ImageURL: b.URL, AltText: b.Alt}
+			// Slack won't show an image without alt text
+			if image.AltText == "" {
+				image.AltText = b.Title
+			}
+			if image.AltText == "" {
+				image.AltText = b.URL
+			}
+			if b.Title != "" {
+				image.Title = plainText(b.Title)
+			}
+			out = append(out, image)
+		case message.Buttons:
+			actions := block{Type: "actions"}
+			for _, button := range b {
+				actions.Elements = append(actions.Elements, &block{Type: "button", Text: plainText(button.Text), URL: button.URL})
+			}
+			if len(actions.Elements) > 0 {
+				out = append(out, actions)
+			}
+		case message.Context:
+			context := block{Type: "context"}
+			for _, s := range b {
+				context.Elements = append(context.Elements, mrkdwn(s))
+			}
+			if len(context.Elements) > 0 {
+				out = append(out, context)
+			}
+		case message.Divider:
+			out = append(out, block{Type: "divider"})
+		default:
+			if s := b.PlainText(); s != "" {
+				out = append(out, block{Type: "section", Text: mrkdwn(s)})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,490 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// pageSize is how many users or channels are asked for in each page of
+// users.list and conversations.list
+const pageSize = 200
+
+// lookupRetry is how long a user that couldn't be looked up isn't asked
+// about again
+const lookupRetry = time.Minute
+
+type member struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	IsBot   bool   `json:"is_bot"`
+
+	RealName string `json:"real_name"`
+	TZ       string `json:"tz"`
+	Profile  struct {
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+// displayName is the name the user has chosen to be shown, falling back to
+// their real name and then their username
+func (m member) displayName() string {
+	switch {
+	case m.Profile.DisplayName != "":
+		return m.Profile.DisplayName
+	case m.RealName != "":
+		return m.RealName
+	}
+	return m.Name
+}
+
+type channel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// directory caches the workspace's users and channels, so that names and
+// mentions can be looked up without asking Slack every time
+type directory struct {
+	mu       sync.RWMutex
+	users    map[string]member  // by ID
+	channels map[string]channel // by ID
+
+	// userIDs and channelIDs are the IDs by lower case username and
+	// channel name
+	userIDs    map[string]string
+	channelIDs map[string]string
+
+	// lookups are when users that aren't in the directory may next be
+	// looked up, by ID
+	lookups map[string]time.Time
+}
+
+func newDirectory() *directory {
+	return &directory{
+		users:      make(map[string]member),
+		channels:   make(map[string]channel),
+		userIDs:    make(map[string]string),
+		channelIDs: make(map[string]string),
+		lookups:    make(map[string]time.Time),
+	}
+}
+
+// setUsers replaces every user with the members
+func (d *directory) setUsers(members []member) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users = make(map[string]member)
+	d.userIDs = make(map[string]string)
+	for _, m := range members {
+		d.putUserLocked(m)
+	}
+}
+
+// setChannels replaces every channel with the channels
+func (d *directory) setChannels(channels []channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels = make(map[string]channel)
+	d.channelIDs = make(map[string]string)
+	for _, c := range channels {
+		d.putChannelLocked(c)
+	}
+}
+
+// putUser adds the user, or replaces them if they've changed
+func (d *directory) putUser(m member) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.putUserLocked(m)
+}
+
+// putUserLocked is putUser with d.mu held
+func (d *directory) putUserLocked(m member) {
+	if old, ok := d.users[m.ID]; ok && d.userIDs[strings.ToLower(old.Name)] == m.ID {
+		delete(d.userIDs, strings.ToLower(old.Name))
+	}
+	d.users[m.ID] = m
+	delete(d.lookups, m.ID)
+	// a deleted user's name can be taken by somebody else
+	if _, taken := d.userIDs[strings.ToLower(m.Name)]; !m.Deleted || !taken {
+		d.userIDs[strings.ToLower(m.Name)] = m.ID
+	}
+}
+
+// putChannel adds the channel, or replaces it if it's been renamed
+func (d *directory) putChannel(c channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.putChannelLocked(c)
+}
+
+// putChannelLocked is putChannel with d.mu held
+func (d *directory) putChannelLocked(c channel) {
+	if old, ok := d.channels[c.ID]; ok && d.channelIDs[strings.ToLower(old.Name)] == c.ID {
+		delete(d.channelIDs, strings.ToLower(old.Name))
+	}
+	d.channels[c.ID] = c
+	d.channelIDs[strings.ToLower(c.Name)] = c.ID
+}
+
+// user returns the user with the ID
+func (d *directory) user(id string) (member, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	m, ok := d.users[id]
+	return m, ok
+}
+
+// startLookup reports whether the user with the ID should be looked up,
+// which they shouldn't be if they're already being looked up or a lookup
+// failed less than lookupRetry ago
+func (d *directory) startLookup(id string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.users[id]; ok || now.Before(d.lookups[id]) {
+		return false
+	}
+	d.lookups[id] = now.Add(lookupRetry)
+	return true
+}
+
+// userNamed returns the user with the username, ignoring case
+func (d *directory) userNamed(name string) (member, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	m, ok := d.users[d.userIDs[strings.ToLower(name)]]
+	return m, ok
+}
+
+// channel returns the channel with the ID
+func (d *directory) channel(id string) (channel, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	c, ok := d.channels[id]
+	return c, ok
+}
+
+// channelNamed returns the channel with the name, ignoring case
+func (d *directory) channelNamed(name string) (channel, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	c, ok := d.channels[d.channelIDs[strings.ToLower(name)]]
+	return c, ok
+}
+
+// keepDirectory loads every user and channel into the directory, and loads
+// them again every DirectoryRefresh until the connection is closed
+func (s *Connection) keepDirectory() {
+	for {
+		if err := s.refreshDirectory(); err != nil {
+			log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not load the Slack directory")
+		}
+		if s.DirectoryRefresh <= 0 {
+			return
+		}
+		select {
+		case <-time.After(s.DirectoryRefresh):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// refreshDirectory replaces the directory's users and channels with every
+// one in the workspace
+func (s *Connection) refreshDirectory() error {
+	var members []member
+	err := s.list("users.list", nil, func(raw []byte) error {
+		var page struct {
+			Members []member `json:"members"`
+		}
+		err := json.Unmarshal(raw, &page)
+		members = append(members, page.Members...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.directory.setUsers(members)
+
+	var channels []channel
+	types := url.Values{"types": {"public_channel,private_channel"}, "exclude_archived": {"true"}}
+	err = s.list("conversations.list", types, func(raw []byte) error {
+		var page struct {
+			Channels []channel `json:"channels"`
+		}
+		err := json.Unmarshal(raw, &page)
+		channels = append(channels, page.Channels...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.directory.setChannels(channels)
+	log.Debugf("Loaded %d Slack users and %d channels", len(members), len(channels))
+	return nil
+}
+
+// list calls one of Slack's paginated list methods, passing each page to
+// read until there are no more
+func (s *Connection) list(method string, params url.Values, read func(raw []byte) error) error {
+	cursor := ""
+	for {
+		query := url.Values{"token": {s.Token}, "limit": {strconv.Itoa(pageSize)}}
+		for k, v := range params {
+			query[k] = v
+		}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		resp, err := apiClient.Get(config.SlackAPIURL + "/" + method + "?" + query.Encode())
+		if err != nil {
+			return err
+		}
+		raw, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		var listResp struct {
+			Ok       bool   `json:"ok"`
+			Error    string `json:"error"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		if err := json.Unmarshal(raw, &listResp); err != nil {
+			return err
+		}
+
+		// Error reponses based on an ok: false
+		if !listResp.Ok {
+			switch listResp.Error {
+			case "not_authed":
+				return errors.New("No authentication token provided.")
+			case "invalid_auth":
+				return errors.New("Invalid authentication token.")
+			case "missing_scope":
+				return errors.New("The bot's token isn't allowed to call " + method + ".")
+			case "ratelimited":
+				return errors.New("The bot is calling " + method + " too often.")
+			default:
+				return errors.New("Something else went wrong. " + method + " status not ok. See https://api.slack.com/methods/" + method)
+			}
+		}
+		if err := read(raw); err != nil {
+			return err
+		}
+		if cursor = listResp.Metadata.NextCursor; cursor == "" {
+			return nil
+		}
+	}
+}
+
+// directoryEvent keeps the directory up to date with a user_change,
+// team_join, channel_created or channel_rename event, reporting whether
+// the event was one of those
+func (s *Connection) directoryEvent(eventType string, raw []byte) bool {
+	switch eventType {
+	case "user_change", "team_join":
+		var event struct {
+			User member `json:"user"`
+		}
+		if err := json.Unmarshal(raw, &event); err == nil && event.User.ID != "" {
+			s.directory.putUser(event.User)
+		}
+	case "channel_created", "channel_rename":
+		var event struct {
+			Channel channel `json:"channel"`
+		}
+		if err := json.Unmarshal(raw, &event); err == nil && event.Channel.ID != "" {
+			s.directory.putChannel(event.Channel)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// user returns the user with the ID from the directory. If they aren't in
+// it they're looked up with users.info in the background, so that they're
+// known next time without holding up the message that mentioned them.
+func (s *Connection) user(userID string) (member, bool) {
+	if m, ok := s.directory.user(userID); ok {
+		return m, true
+	}
+	if s.directory.startLookup(userID, time.Now()) {
+		go func() {
+			m, err := s.getUserInfo(userID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"User":  userID,
+					"Error": err.Error(),
+				}).Warn("Could not look up Slack user")
+				return
+			}
+			s.directory.putUser(m)
+		}()
+	}
+	return member{}, false
+}
+
+// userName returns the display name for the Slack user ID. If the user
+// isn't known yet the ID is returned so plugins always have something to
+// show.
+func (s *Connection) userName(userID string) string {
+	m, ok := s.user(userID)
+	if !ok {
+		return userID
+	}
+	return m.displayName()
+}
+
+// resolve returns the ID of the channel to post to for a channel ID, a
+// channel's name like "#general", or a user's ID or username like "@bob",
+// which are sent a direct message
+func (s *Connection) resolve(channel string) (string, error) {
+	switch {
+	case strings.HasPrefix(channel, "#"):
+		c, ok := s.directory.channelNamed(channel[1:])
+		if !ok {
+			return "", errors.New("there's no channel " + channel)
+		}
+		return c.ID, nil
+	case strings.HasPrefix(channel, "@"):
+		m, ok := s.directory.userNamed(channel[1:])
+		if !ok {
+			return "", errors.New("there's no user " + channel)
+		}
+		channel = m.ID
+	}
+	if isUserID(channel) {
+		return s.dmChannel(channel)
+	}
+	return channel, nil
+}
+
+// reSlackMention matches Slack's markup for mentions of users ("<@U123>"),
+// channels ("<#C123|general>") and special mentions ("<!here>")
+var reSlackMention = regexp.MustCompile(`<([@#!])([^>|]+)(?:\|([^>]*))?>`)
+
+// readableText turns the Slack markup for mentions in incoming text into
+// names people would type, e.g. "<@U123>" into "@bob" and "<#C123>" into
+// "#general". It returns the users mentioned, by how they now appear in the
+// text. Mentions of users who aren't in the directory yet are left alone.
+func (s *Connection) readableText(text string) (string, map[string]message.User) {
+	mentions := make(map[string]message.User)
+	text = reSlackMention.ReplaceAllStringFunc(text, func(markup string) string {
+		parts := reSlackMention.FindStringSubmatch(markup)
+		kind, id, label := parts[1], parts[2], parts[3]
+		switch kind {
+		case "@":
+			m, ok := s.user(id)
+			if !ok {
+				if label != "" {
+					return "@" + label
+				}
+				return markup
+			}
+			name := "@" + m.Name
+			mentions[name] = message.User{ID: m.ID, Name: m.displayName(), Mention: "<@" + m.ID + ">"}
+			return name
+		case "#":
+			if c, ok := s.directory.channel(id); ok {
+				return "#" + c.Name
+			}
+			if label != "" {
+				return "#" + label
+			}
+		case "!":
+			switch {
+			case id == "here", id == "channel", id == "everyone":
+				return "@" + id
+			case label != "":
+				// user groups and dates come with a label to show
+				return label
+			}
+		}
+		return markup
+	})
+	return text, mentions
+}
+
+// reMention matches a mention of a user ("@bob") or channel ("#general")
+// in outgoing text that isn't part of a word, email address or URL
+var reMention = regexp.MustCompile(`(^|[^\w@#<|/&])([@#])(\w[\w.\-]*)`)
+
+// slackText turns mentions of known users and channels in outgoing text,
+// e.g. "@bob" and "#general", into Slack's markup for them, so that the
+// user is notified and the channel is linked. Text in backticks is left
+// alone, and so is "@channel", which is never turned into a ping.
+func (s *Connection) slackText(text string) string {
+	parts := strings.Split(text, "`")
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = reMention.ReplaceAllStringFunc(parts[i], s.slackMention)
+	}
+	return strings.Join(parts, "`")
+}
+
+// slackMention turns one match of reMention into Slack's markup
+func (s *Connection) slackMention(match string) string {
+	parts := reMention.FindStringSubmatch(match)
+	before, kind, name := parts[1], parts[2], parts[3]
+	// a mention at the end of a sentence doesn't include its full stop
+	after := name[len(strings.TrimRight(name, ".-")):]
+	name = name[:len(name)-len(after)]
+
+	// "@here" and the like stay plain text. Plugins ask to ping the
+	// channel with the reply's Notify.
+	switch {
+	case kind == "@":
+		if m, ok := s.directory.userNamed(name); ok {
+			return before + "<@" + m.ID + ">" + after
+		}
+	case kind == "#":
+		if c, ok := s.directory.channelNamed(name); ok {
+			return before + "<#" + c.ID + ">" + after
+		}
+	}
+	return match
+}
+
+// slackBlocks turns mentions in the text of a rich reply's sections and
+// context into Slack's markup, as slackText does
+func (s *Connection) slackBlocks(blocks []message.Block) []message.Block {
+	if len(blocks) == 0 {
+		return nil
+	}
+	out := make([]message.Block, len(blocks))
+	for i, b := range blocks {
+		switch b := b.(type) {
+		case message.Section:
+			section := message.Section{Text: s.slackText(b.Text)}
+			for _, f := range b.Fields {
+				section.Fields = append(section.Fields, message.Field{Title: f.Title, Value: s.slackText(f.Value)})
+			}
+			out[i] = section
+		case message.Context:
+			context := make(message.Context, len(b))
+			for j, text := range b {
+				context[j] = s.slackText(text)
+			}
+			out[i] = context
+		default:
+			out[i] = b
+		}
+	}
+	return out
+}
@@ -0,0 +1,288 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+const (
+	// maxEventAge is how old a request's timestamp may be before it's
+	// rejected, so that captured requests can't be replayed
+	maxEventAge = 5 * time.Minute
+
+	// seenFor is how long event IDs are remembered so that Slack's
+	// retries aren't handled twice
+	seenFor = time.Hour
+)
+
+// EventsConnection is a Connection to Slack that receives events over HTTP
+// from the Events API (https://api.slack.com/apis/connections/events-api)
+// and replies with chat.postMessage, for Slack apps that can't use the RTM.
+// It's an http.Handler, so it can also be mounted on an existing server.
+//
+// Point the app's Request URL at Addr, subscribe it to the message events
+// and give the connection the app's bot token and signing secret
+//
+//	slackConnection := NewEventsConnection("xoxb-1234", "signing-secret", ":3000")
+type EventsConnection struct {
+	// Addr is the address to listen on. If it's empty, Start doesn't listen
+	// and the EventsConnection should be served by something else.
+	Addr string
+
+	// SigningSecret is the app's signing secret, which every request must
+	// be signed with
+	SigningSecret string
+
+	// ThreadReplies lists the IDs of the channels where every reply starts
+	// a thread, as for Connection
+	ThreadReplies []string
+
+	// DirectoryRefresh is how often every user and channel in the workspace
+	// is loaded again, as for Connection
+	DirectoryRefresh time.Duration
+
+	// api calls the Web API with the bot's token, caching what it looks up,
+	// and holds the inbox and status
+	api *Connection
+
+	rx message.BasicChannel
+
+	// seen is when each event was received, by event ID
+	seen   map[string]time.Time
+	seenMu sync.Mutex
+
+	listener   net.Listener
+	listenerMu sync.Mutex
+}
+
+// NewEventsConnection creates a connection that listens for events on addr
+func NewEventsConnection(token, signingSecret, addr string) *EventsConnection {
+	return &EventsConnection{
+		Addr:             addr,
+		SigningSecret:    signingSecret,
+		DirectoryRefresh: time.Hour,
+		api:              NewConnection(token),
+		rx:               make(message.BasicChannel),
+		seen:             make(map[string]time.Time),
+	}
+}
+
+// Start looks up the bot's own user, starts listening on Addr if it's set
+// and creates the transmit goroutine that posts replies
+func (s *EventsConnection) Start(errorChannel chan error) (rx, tx message.BasicChannel) {
+	tx = make(message.BasicChannel)
+	botID, err := apiTokenAuthTest(s.api.Token)
+	if err != nil {
+		errorChannel <- err
+		return s.rx, tx
+	}
+	s.api.botID = botID
+	s.api.DirectoryRefresh = s.DirectoryRefresh
+	go s.api.keepDirectory()
+	go s.startTX(tx)
+
+	if s.Addr != "" {
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			errorChannel <- err
+			return s.rx, tx
+		}
+		s.listenerMu.Lock()
+		s.listener = ln
+		s.listenerMu.Unlock()
+		go func() {
+			err := http.Serve(ln, s)
+			select {
+			case <-s.api.done:
+			case errorChannel <- err:
+			}
+		}()
+		log.Infof("Listening for Slack events on %s", ln.Addr())
+	}
+	s.api.updateStatus(func(status *connection.Status) { status.Connected = true })
+	return s.rx, tx
+}
+
+// Close stops listening and posting replies
+func (s *EventsConnection) Close() error {
+	err := s.api.Close()
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	if s.listener != nil {
+		if closeErr := s.listener.Close(); err == nil {
+			err = closeErr
+		}
+		s.listener = nil
+	}
+	return err
+}
+
+// Post sends the text to the Slack channel, as Connection's Post does
+func (s *EventsConnection) Post(channel, text string) error {
+	if s.api.closed() {
+		return errClosed
+	}
+	channel, err := s.api.resolve(channel)
+	if err != nil {
+		return err
+	}
+	m := Message{Channel: channel}
+	m.Text = s.api.slackText(text)
+	return s.api.postMessage(m)
+}
+
+// Status reports whether the connection is listening, when Slack last sent
+// an event and how many messages are waiting for a reply
+func (s *EventsConnection) Status() connection.Status {
+	return s.api.Status()
+}
+
+// startTX posts each reply to the channel its message came from
+func (s *EventsConnection) startTX(tx message.BasicChannel) {
+	for {
+		select {
+		case <-s.api.done:
+			return
+		case msg := <-tx:
+			out, ok := s.api.reply(msg, s.ThreadReplies)
+			if !ok {
+				continue
+			}
+			if err := s.api.postMessage(out); err != nil {
+				log.WithFields(log.Fields{
+					"Channel": out.Channel,
+					"Error":   err.Error(),
+				}).Warn("Could not send reply to Slack")
+			}
+		}
+	}
+}
+
+// ServeHTTP answers Slack's URL verification challenge and sends the
+// messages in event callbacks to the bot. Requests that aren't signed with
+// the SigningSecret are rejected, and retries of events that have already
+// been received are acknowledged without being sent again.
+func (s *EventsConnection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "events must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifySignature(s.SigningSecret, r.Header, body, time.Now()); err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warn("Rejected Slack event")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var callback struct {
+		Type      string          `json:"type"`
+		Challenge string          `json:"challenge"`
+		EventID   string          `json:"event_id"`
+		Event     json.RawMessage `json:"event"`
+	}
+	if err := json.Unmarshal(body, &callback); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.api.updateStatus(func(status *connection.Status) { status.LastEvent = time.Now() })
+
+	switch callback.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(callback.Challenge))
+		return
+	case "event_callback":
+		if s.firstTime(callback.EventID) {
+			s.handleEvent(callback.Event)
+		} else {
+			log.Debugf("Ignoring retry of Slack event %s", callback.EventID)
+		}
+	default:
+		log.Debug("Ignoring Slack callback: ", callback.Type)
+	}
+	// Slack retries anything that isn't acknowledged within three seconds,
+	// so events are answered straight away and handled in the background
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleEvent sends a message event to the bot, unless it's from the bot,
+// and keeps the directory up to date with changes to users and channels
+func (s *EventsConnection) handleEvent(raw json.RawMessage) {
+	var m Message
+	if err := json.Unmarshal(raw, &m); err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not read Slack event")
+		return
+	}
+	if s.api.directoryEvent(m.Type, raw) {
+		return
+	}
+	if m.Type != "message" || m.User == "" || m.User == s.api.botID {
+		return
+	}
+	go func() {
+		select {
+		case s.rx <- s.api.received(m, raw):
+		case <-s.api.done:
+		}
+	}()
+}
+
+// firstTime reports whether the event hasn't been received before, and
+// forgets events received more than seenFor ago
+func (s *EventsConnection) firstTime(eventID string) bool {
+	now := time.Now()
+	s.seenMu.Lock()
+	defer s.seenMu.Unlock()
+	for id, at := range s.seen {
+		if now.Sub(at) > seenFor {
+			delete(s.seen, id)
+		}
+	}
+	if eventID == "" {
+		return true
+	}
+	if _, ok := s.seen[eventID]; ok {
+		return false
+	}
+	s.seen[eventID] = now
+	return true
+}
+
+// verifySignature checks the request was signed with the signing secret
+// recently. See https://api.slack.com/authentication/verifying-requests-from-slack
+func verifySignature(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing request timestamp")
+	}
+	if age := now.Sub(time.Unix(secs, 0)); age > maxEventAge || age < -maxEventAge {
+		return errors.New("request timestamp is too far from now")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(header.Get("X-Slack-Signature"))) {
+		return errors.New("invalid request signature")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// deliver POSTs the body to the connection, signed with secret at the time
+func deliver(s *EventsConnection, body, secret string, at time.Time, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestEventsConnection(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := NewEventsConnection("xoxb-test", "secret", "")
+	defer s.Close()
+	errs := make(chan error, 1)
+	rx, tx := s.Start(errs)
+	now := time.Now()
+
+	w := deliver(s, `{"type": "url_verification", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`, "secret", now)
+	if w.Code != http.StatusOK || w.Body.String() != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("url verification = %d %q", w.Code, w.Body.String())
+	}
+	if w := deliver(s, `{"type": "url_verification"}`, "wrong", now); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret = %d, want 401", w.Code)
+	}
+	if w := deliver(s, `{"type": "url_verification"}`, "secret", now.Add(-time.Hour)); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request = %d, want 401", w.Code)
+	}
+
+	// senders' names come from the directory, which loads in the background
+	waitFor(t, "the directory loads", func() bool {
+		_, ok := s.api.directory.user("U1")
+		return ok
+	})
+	event := `{"type": "event_callback", "event_id": "Ev1", "event": {"type": "message", "channel": "C1", "user": "U1", "text": "!ping", "ts": "2.0", "thread_ts": "1.0"}}`
+	if w := deliver(s, event, "secret", now); w.Code != http.StatusOK {
+		t.Errorf("event = %d", w.Code)
+	}
+	var in message.Basic
+	select {
+	case in = <-rx:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	if in.Text != "!ping" || in.Sender.Name != "alice" || in.Sender.TimeZone != "America/New_York" || in.Channel != "C1" || in.Thread != "1.0" || in.Connection != "slack" {
+		t.Errorf("received %+v", in)
+	}
+
+	// Retries and the bot's own messages aren't sent to the bot
+	deliver(s, event, "secret", now, "X-Slack-Retry-Num", "1", "X-Slack-Retry-Reason", "http_timeout")
+	deliver(s, `{"type": "event_callback", "event_id": "Ev2", "event": {"type": "message", "channel": "C1", "user": "UBOT", "text": "!ping"}}`, "secret", now)
+	select {
+	case m := <-rx:
+		t.Errorf("received %+v again", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tx <- message.Basic{ID: in.ID, Text: "pong"}
+	tx <- message.Basic{ID: in.ID, Finished: true}
+	select {
+	case m := <-f.posts:
+		if m.Channel != "C1" || m.Text != "<@U1>: pong" || m.ThreadTimestamp != "1.0" {
+			t.Errorf("posted %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply posted")
+	}
+	waitFor(t, "the inbox emptied", func() bool { return s.Status().Inbox == 0 })
+
+	if err := s.Post("C2", "Standup!"); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-f.posts; m.Channel != "C2" || m.Text != "Standup!" {
+		t.Errorf("posted %+v", m)
+	}
+	select {
+	case err := <-errs:
+		t.Error(err)
+	default:
+	}
+}
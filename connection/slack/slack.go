@@ -7,23 +7,168 @@ Once you've created a bot user, you will need to initialize the Slack connection
 using the API key for this bot user.
 
  slackConnection := NewConnection("MySlackBotAPIKey")
+
+If the websocket dies, because Slack says goodbye, stops answering pings or
+the network drops, the connection reconnects with exponential backoff. Messages
+still waiting for replies are kept, and replies sent while it's reconnecting
+go out once it's back. The bot is only told the connection failed once
+MaxReconnects attempts in a row have failed, or Slack rejects the token.
+
+Newer Slack apps can't use the RTM. They receive events over HTTP from the
+Events API instead, with an EventsConnection.
+
+Both kinds of connection keep a directory of the workspace's users and
+channels, so that mentions in incoming messages reach the bot as names like
+"@bob" and "#general", and names in replies go out as mentions.
 */
 package slack
 
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/connection"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
 
 	"golang.org/x/net/websocket"
 )
 
+// connectionName is set as the Connection on every message's envelope
+const connectionName = "slack"
+
+// sendAttempts is how many websockets a message is tried on before it's
+// given up on
+const sendAttempts = 3
+
+var (
+	errClosed  = errors.New("slack connection is closed")
+	errGaveUp  = errors.New("slack connection gave up reconnecting")
+	errNoPongs = errors.New("slack stopped answering pings")
+)
+
+func init() {
+	connection.Register("slack", fromSettings)
+}
+
+// fromSettings creates an RTM or Events API connection from its section of
+// the configuration file
+func fromSettings(section config.Section) (connection.Connection, error) {
+	var settings struct {
+		Token         string   `yaml:"token"`
+		Mode          string   `yaml:"mode"`
+		MaxReconnects *int     `yaml:"max_reconnects"`
+		SigningSecret string   `yaml:"signing_secret"`
+		Addr          string   `yaml:"addr"`
+		ThreadReplies []string `yaml:"thread_replies"`
+
+		DirectoryRefresh *time.Duration `yaml:"directory_refresh"`
+	}
+	if err := section.Decode(&settings); err != nil {
+		return nil, err
+	}
+	if settings.Token == "" {
+		return nil, errors.New("token: slack connections need the bot's API token")
+	}
+	switch settings.Mode {
+	case "", "rtm":
+	case "events":
+		if settings.SigningSecret == "" {
+			return nil, errors.New("signing_secret: slack events connections need the app's signing secret")
+		}
+		if settings.Addr == "" {
+			return nil, errors.New("addr: slack events connections need an address to listen on, e.g. :3000")
+		}
+		conn := NewEventsConnection(settings.Token, settings.SigningSecret, settings.Addr)
+		conn.ThreadReplies = settings.ThreadReplies
+		if settings.DirectoryRefresh != nil {
+			conn.DirectoryRefresh = *settings.DirectoryRefresh
+		}
+		return conn, nil
+	default:
+		return nil, fmt.Errorf("mode: %q isn't a slack mode, try rtm or events", settings.Mode)
+	}
+	conn := NewConnection(settings.Token)
+	conn.ThreadReplies = settings.ThreadReplies
+	if settings.MaxReconnects != nil {
+		conn.MaxReconnects = *settings.MaxReconnects
+	}
+	if settings.DirectoryRefresh != nil {
+		conn.DirectoryRefresh = *settings.DirectoryRefresh
+	}
+	return conn, nil
+}
+
 // Connection provides an interface for storing the Slack API key and the inbox for storing received messages
 type Connection struct {
-	Token string
-	Inbox map[int]Message
+	Token   string
+	Inbox   map[int]Message
+	inboxMu sync.Mutex
+
+	// MaxReconnects is how many attempts to connect may fail in a row before
+	// the connection gives up and reports an error. Zero or less means it
+	// never gives up.
+	MaxReconnects int
+
+	// MinBackoff is how long the connection waits before its second attempt
+	// to connect. The wait doubles with each attempt, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// ThreadReplies lists the IDs of the channels where every reply starts
+	// a thread on the message it answers, rather than going in the channel.
+	// "*" means every channel. Direct messages are always answered directly.
+	ThreadReplies []string
+
+	// PingInterval is how often Slack is pinged. If Slack hasn't answered
+	// a ping after PongTimeout, the websocket is reconnected.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// DirectoryRefresh is how often every user and channel in the workspace
+	// is loaded again. Zero or less means they're only loaded once, and kept
+	// up to date by Slack's events.
+	DirectoryRefresh time.Duration
+
+	// directory caches the workspace's users and channels
+	directory *directory
+
+	// dmChannels caches the direct message channel ID by user ID
+	dmChannels   map[string]string
+	dmChannelsMu sync.Mutex
+
+	// posts are messages that aren't replies, sent by the TX goroutine
+	posts chan post
+
+	// status is reported by Status, and guarded by statusMu
+	status   connection.Status
+	statusMu sync.Mutex
+
+	// ws is the open websocket, or nil while reconnecting. wsChanged is
+	// closed, and replaced, whenever ws changes. Both are guarded by wsMu.
+	ws        *websocket.Conn
+	wsChanged chan struct{}
+	wsMu      sync.Mutex
+
+	// reconnectURL is the websocket URL Slack last said to reconnect to.
+	// It's only used by the RX goroutine, as is botID.
+	reconnectURL string
+	botID        string
+
+	// counter is the ID of the next message added to the inbox, and is
+	// guarded by inboxMu
+	counter int
+
+	msgIDs    <-chan int
+	done      chan struct{}
+	failed    chan struct{} // closed once the connection has given up
+	closeOnce sync.Once
 }
 
 // Message provides the interface for all Slack messages
@@ -33,136 +178,509 @@ type Message struct {
 	Channel   string `json:"channel"`
 	User      string `json:"user"`
 	Timestamp string `json:"ts"`
+
+	// ThreadTimestamp is the timestamp of the first message in the thread
+	// the message is part of. ReplyBroadcast shows a reply in a thread in
+	// the channel too.
+	ThreadTimestamp string `json:"thread_ts,omitempty"`
+	ReplyBroadcast  bool   `json:"reply_broadcast,omitempty"`
 }
 
 // NewConnection returns a new Connection to Slack
 func NewConnection(slackAPIKey string) *Connection {
 	return &Connection{
-		slackAPIKey,
-		make(map[int]Message),
+		Token:            slackAPIKey,
+		Inbox:            make(map[int]Message),
+		MaxReconnects:    10,
+		MinBackoff:       time.Second,
+		MaxBackoff:       time.Minute,
+		PingInterval:     15 * time.Second,
+		PongTimeout:      45 * time.Second,
+		DirectoryRefresh: time.Hour,
+		directory:        newDirectory(),
+		dmChannels:       make(map[string]string),
+		posts:            make(chan post),
+		wsChanged:        make(chan struct{}),
+		msgIDs:           messageIDGen(0, 1),
+		done:             make(chan struct{}),
+		failed:           make(chan struct{}),
 	}
 }
 
-// Start creates the connection for the Slack RTM and creates the transmit and receive
-// goroutines that listen and send messages through the tx and rx channels
+// Close stops the RX, TX and keepalive goroutines and closes the websocket
+func (s *Connection) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wsMu.Lock()
+		if s.ws != nil {
+			err = s.ws.Close()
+		}
+		s.wsMu.Unlock()
+		s.updateStatus(func(status *connection.Status) { status.Connected = false })
+	})
+	return err
+}
+
+// closed reports whether Close has been called
+func (s *Connection) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start connects to the Slack RTM in the background and creates the transmit
+// and receive goroutines that listen and send messages through the tx and rx
+// channels. The only error sent on errorChannel is the one that makes the
+// connection give up.
 func (s *Connection) Start(errorChannel chan error) (rx, tx message.BasicChannel) {
 	rx = make(message.BasicChannel)
 	tx = make(message.BasicChannel)
-	wssurl, err := getWSSUrl(s.Token)
+	go s.keepDirectory()
+	go s.run(rx, errorChannel)
+	go s.startTX(tx)
+	return rx, tx
+}
+
+// post is a message to send to a channel that isn't a reply
+type post struct {
+	channel, text string
+	sent          chan error
+}
+
+// Post sends the text to the Slack channel, which may be a channel, group
+// or direct message ID, or a channel's name, e.g. "#general". If it's a user
+// ID, or a username like "@bob", the text is sent to the user as a direct
+// message.
+func (s *Connection) Post(channel, text string) error {
+	channel, err := s.resolve(channel)
 	if err != nil {
-		errorChannel <- err
-		panic(err) // TODO
+		return err
 	}
-	// Connect to the websocket
-	ws, err := websocket.Dial(wssurl, "", "http://localhost/")
-	if err != nil {
-		log.Fatal(err)
+	text = s.slackText(text)
+	p := post{channel: channel, text: text, sent: make(chan error, 1)}
+	select {
+	case s.posts <- p:
+	case <-s.done:
+		return errClosed
 	}
-	// defer ws.Close() // TODO
+	return <-p.sent
+}
 
-	// start message Id generator
-	msgChan := messageIDGen(0, 1)
+// Status reports whether the websocket is open, when Slack last sent an
+// event and answered a ping, how many messages are waiting for a reply and
+// how many times the websocket has reconnected
+func (s *Connection) Status() connection.Status {
+	s.inboxMu.Lock()
+	inbox := len(s.Inbox)
+	s.inboxMu.Unlock()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	status := s.status
+	status.Inbox = inbox
+	return status
+}
 
-	// run keepalive to keep the websocket connection running
-	go keepalive(ws, msgChan)
+// updateStatus changes the status under its lock
+func (s *Connection) updateStatus(fn func(*connection.Status)) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	fn(&s.status)
+}
 
-	// start the RX and TX methods
-	go s.startRX(ws, rx, errorChannel)
-	go s.startTX(ws, tx, msgChan, errorChannel)
-	return rx, tx
+// run connects to Slack and receives its events, reconnecting whenever the
+// websocket dies, until the connection is closed or gives up
+func (s *Connection) run(rx message.BasicChannel, errorChannel chan error) {
+	connected := false
+	for failures := 0; ; {
+		ws, err := s.connect()
+		if s.closed() {
+			if ws != nil {
+				ws.Close()
+			}
+			return
+		}
+		if err != nil {
+			failures++
+			_, rejected := err.(authError)
+			if rejected || (s.MaxReconnects > 0 && failures >= s.MaxReconnects) {
+				s.updateStatus(func(status *connection.Status) { status.GaveUp = true })
+				close(s.failed)
+				select {
+				case errorChannel <- fmt.Errorf("could not connect to slack after %d attempts: %s", failures, err.Error()):
+				case <-s.done:
+				}
+				return
+			}
+			wait := s.backoff(failures)
+			log.WithFields(log.Fields{
+				"Attempt": failures,
+				"Error":   err.Error(),
+			}).Warnf("Could not connect to Slack, trying again in %s", wait)
+			select {
+			case <-time.After(wait):
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		if connected {
+			s.updateStatus(func(status *connection.Status) { status.Reconnects++ })
+			log.Info("Reconnected to Slack")
+		}
+		connected = true
+		failures = 0
+		if err := s.receive(ws, rx); err != nil && !s.closed() {
+			log.WithFields(log.Fields{"Error": err.Error()}).Warn("Slack websocket died, reconnecting")
+		}
+		if s.closed() {
+			return
+		}
+	}
 }
 
-// startRX listens to all Slack messages. It adds all messages of type 'message' to the inbox and
-// adds the message with text only (m.Basic) to the rx channel. Messages send into the rx channel
-// are sent to the messagePump, which sends the message to each plugin
-func (s *Connection) startRX(ws *websocket.Conn, rx message.BasicChannel, errorChannel chan error) {
-	// get info of bot
-	BotID, err := apiTokenAuthTest(s.Token)
+// connect looks up the bot's own user the first time, then opens a
+// websocket to the URL Slack last gave to reconnect to, or to a new one
+// from rtm.start
+func (s *Connection) connect() (*websocket.Conn, error) {
+	if s.botID == "" {
+		id, err := apiTokenAuthTest(s.Token)
+		if err != nil {
+			return nil, err
+		}
+		s.botID = id
+	}
+	if url := s.reconnectURL; url != "" {
+		s.reconnectURL = ""
+		ws, err := websocket.Dial(url, "", "http://localhost/")
+		if err == nil {
+			return ws, nil
+		}
+		log.WithFields(log.Fields{"Error": err.Error()}).Debug("Could not use Slack's reconnect URL")
+	}
+	wssurl, err := getWSSUrl(s.Token)
 	if err != nil {
-		errorChannel <- err
+		return nil, err
 	}
+	return websocket.Dial(wssurl, "", "http://localhost/")
+}
+
+// backoff returns how long to wait after the failures'th failed attempt to
+// connect: MinBackoff doubling with each failure up to MaxBackoff, less up
+// to half of it at random so that bots don't all reconnect at once
+func (s *Connection) backoff(failures int) time.Duration {
+	d := s.MinBackoff
+	for i := 1; i < failures && d < s.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.MaxBackoff {
+		d = s.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// setSocket makes ws the websocket replies are sent on. It's nil while the
+// connection is reconnecting.
+func (s *Connection) setSocket(ws *websocket.Conn) {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	s.ws = ws
+	close(s.wsChanged)
+	s.wsChanged = make(chan struct{})
+	s.updateStatus(func(status *connection.Status) { status.Connected = ws != nil })
+}
+
+// socket returns the open websocket, waiting for the connection to
+// reconnect if it's down or the only one open is broken
+func (s *Connection) socket(broken *websocket.Conn) (*websocket.Conn, error) {
+	for {
+		s.wsMu.Lock()
+		ws, changed := s.ws, s.wsChanged
+		s.wsMu.Unlock()
+		if ws != nil && ws != broken {
+			return ws, nil
+		}
+		select {
+		case <-changed:
+		case <-s.done:
+			return nil, errClosed
+		case <-s.failed:
+			return nil, errGaveUp
+		}
+	}
+}
+
+// send writes v to the websocket. If that fails the websocket is closed,
+// so it's reconnected, and v is sent on the new one.
+func (s *Connection) send(v interface{}) (err error) {
+	var ws *websocket.Conn
+	for attempt := 0; attempt < sendAttempts; attempt++ {
+		if ws, err = s.socket(ws); err != nil {
+			return err
+		}
+		if err = websocket.JSON.Send(ws, v); err == nil {
+			return nil
+		}
+		log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not send to Slack, reconnecting")
+		ws.Close()
+	}
+	return err
+}
+
+// receive reads events from the websocket until it dies or Slack says
+// goodbye. It adds all messages of type 'message' to the inbox and adds
+// the message (m.Basic), with its envelope filled in from the Slack event,
+// to the rx channel. Messages sent into the rx channel are sent to the
+// messagePump, which sends the message to each plugin.
+func (s *Connection) receive(ws *websocket.Conn, rx message.BasicChannel) error {
+	s.setSocket(ws)
+	defer s.setSocket(nil)
+	defer ws.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.keepalive(ws, stop)
 
-	// run infinite loop for receiving messages
-	counter := 0
 	for {
 		var raw json.RawMessage
-		err := websocket.JSON.Receive(ws, &raw)
-		if err != nil {
-			errorChannel <- err
+		if err := websocket.JSON.Receive(ws, &raw); err != nil {
+			return err
 		}
+		s.updateStatus(func(status *connection.Status) { status.LastEvent = time.Now() })
 
 		var event struct {
 			Type    string          `json:"type"`
 			Error   json.RawMessage `json:"error"`
 			ReplyTo int             `json:"reply_to"`
+			URL     string          `json:"url"`
 		}
-		err = json.Unmarshal(raw, &event)
-		if err != nil {
-			errorChannel <- err
+		if err := json.Unmarshal(raw, &event); err != nil {
+			log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not read Slack event")
+			continue
 		}
 
 		switch event.Type {
 		case "":
 			log.Debug("Acknowledge message: ", event.ReplyTo)
-		case "pong", "presence_change", "user_typing", "reconnect_url":
+		case "pong":
+			s.updateStatus(func(status *connection.Status) { status.LastPong = time.Now() })
+		case "presence_change", "user_typing":
 			continue
+		case "user_change", "team_join", "channel_created", "channel_rename":
+			s.directoryEvent(event.Type, raw)
+		case "reconnect_url":
+			s.reconnectURL = event.URL
+		case "goodbye":
+			return errors.New("slack said goodbye")
 		case "hello":
 			// Send response to hello straight into websocket without going through messagePump
 			log.Debug("Hello Event: ", event.Type)
 		case "message":
 			var m Message
-			err = json.Unmarshal(raw, &m)
-			if err != nil {
-				errorChannel <- err
+			if err := json.Unmarshal(raw, &m); err != nil {
+				log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not read Slack message")
+				continue
 			}
-			m.Basic.Text = formatSlackMsg(m.Basic.Text)
 			log.Debugf("Full msg: %v\n", m)
 
 			// if the message is not from the configured Bot
 			// we don't want the bot responding to its own messages
-			if m.User != BotID {
-				// returns response string
-				m.Basic.ID = counter
-				s.Inbox[counter] = m
-				rx <- m.Basic
-				counter++
+			if m.User != s.botID {
+				select {
+				case rx <- s.received(m, raw):
+				case <-s.done:
+					return nil
+				}
 			}
 		}
 	}
 }
 
+// received fills in the message's envelope from the Slack event and adds it
+// to the inbox, returning the message to send to the bot
+func (s *Connection) received(m Message, raw json.RawMessage) message.Basic {
+	var mentions map[string]message.User
+	m.Basic.Text, mentions = s.readableText(formatSlackMsg(m.Basic.Text))
+	sender := message.User{ID: m.User, Name: m.User, Mention: "<@" + m.User + ">"}
+	if member, ok := s.user(m.User); ok {
+		sender.Name, sender.TimeZone = member.displayName(), member.TZ
+	}
+	m.Basic.Envelope = message.Envelope{
+		Sender:     sender,
+		Channel:    m.Channel,
+		Thread:     m.ThreadTimestamp,
+		Timestamp:  parseTimestamp(m.Timestamp),
+		Connection: connectionName,
+		Direct:     strings.HasPrefix(m.Channel, "D"),
+		Mentions:   mentions,
+		Raw:        raw,
+	}
+
+	// returns response string
+	s.inboxMu.Lock()
+	defer s.inboxMu.Unlock()
+	m.Basic.ID = s.counter
+	s.Inbox[s.counter] = m
+	s.counter++
+	return m.Basic
+}
+
+// keepalive pings Slack every PingInterval until stop is closed. If Slack
+// stops answering for PongTimeout, or a ping can't be sent, it closes the
+// websocket so that it's reconnected.
+func (s *Connection) keepalive(ws *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.PingInterval)
+	defer ticker.Stop()
+
+	// unanswered is when the oldest ping Slack hasn't answered was sent
+	var unanswered time.Time
+	for {
+		now := time.Now()
+		if !unanswered.IsZero() && s.Status().LastPong.After(unanswered) {
+			unanswered = time.Time{}
+		}
+		if !unanswered.IsZero() && now.Sub(unanswered) >= s.PongTimeout {
+			log.WithFields(log.Fields{"Error": errNoPongs.Error()}).Warn("Slack websocket is dead, reconnecting")
+			ws.Close()
+			return
+		}
+		if unanswered.IsZero() {
+			unanswered = now
+		}
+
+		err := websocket.JSON.Send(ws, struct {
+			ID   int    `json:"id"`
+			Type string `json:"type"`
+		}{ID: <-s.msgIDs, Type: "ping"})
+		if err != nil {
+			log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not ping Slack, reconnecting")
+			ws.Close()
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // startTX is responsible for listening on the tx channel and sending all non-blank messages back through the
 // websocket connection. The outgoing message is reassembled from the text from the tx channel and the rest of
 // the original message attributes. Since this is the Slack startTX, it add a mention before the text to alert
-// user that sent the original message that the bot has responded
-func (s *Connection) startTX(ws *websocket.Conn, tx message.BasicChannel, msgChan <-chan int, errorChannel chan error) {
+// user that sent the original message that the bot has responded. The RTM can't send blocks, so rich replies
+// are posted with the Web API instead.
+func (s *Connection) startTX(tx message.BasicChannel) {
 	for {
 		select {
+		case <-s.done:
+			return
+		case p := <-s.posts:
+			out := Message{Type: "message", Channel: p.channel}
+			out.Text = p.text
+			out.ID = <-s.msgIDs
+			p.sent <- s.send(&out)
 		case msg := <-tx:
-			// handle everything except blank messages
-			if msg.Text != "" {
-
-				out, ok := s.Inbox[msg.ID]
-				if ok != true {
-					errorChannel <- errors.New("unknown id")
-				}
-				// get the UserId from the message sent
-				// Add it to the beginning of the text
-				msgUser := "<@" + out.User + ">: "
-				out.Text = msgUser + msg.Text
-				id := <-msgChan
-				out.ID = id
-
-				// send response struct
-				err := websocket.JSON.Send(ws, &out)
-				if err != nil {
-					errorChannel <- err
-				}
-				if msg.Finished {
-					delete(s.Inbox, msg.ID)
+			out, ok := s.reply(msg, s.ThreadReplies)
+			if !ok {
+				continue
+			}
+			if len(out.Blocks) > 0 {
+				if err := s.postMessage(out); err != nil {
+					log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not post rich reply to Slack")
 				}
-				log.Debug("inbox size: ", len(s.Inbox))
+				continue
 			}
+			out.ID = <-s.msgIDs
+
+			// send response struct
+			if err := s.send(&out); err != nil {
+				log.WithFields(log.Fields{"Error": err.Error()}).Warn("Could not send reply to Slack")
+			}
+		}
+	}
+}
+
+// reply reassembles the reply to send for a message from the tx channel
+// from its text and the original message in the inbox, which is removed
+// once the bot has finished replying. It reports false for blank messages,
+// which aren't sent. The reply goes in the thread the reply's Placement
+// asks for, or by default in the original message's thread, starting one
+// in the threads channels.
+func (s *Connection) reply(msg message.Basic, threads []string) (out Message, ok bool) {
+	s.inboxMu.Lock()
+	out, ok = s.Inbox[msg.ID]
+	if msg.Finished {
+		delete(s.Inbox, msg.ID)
+	}
+	log.Debug("inbox size: ", len(s.Inbox))
+	s.inboxMu.Unlock()
+
+	// handle everything except blank messages
+	if msg.Text == "" {
+		return out, false
+	}
+	if !ok {
+		log.Warnf("Dropping reply to unknown Slack message %d", msg.ID)
+		return out, false
+	}
+	// get the UserId from the message sent
+	// Add it to the beginning of the text
+	msgUser := "<@" + out.User + ">: "
+	if msg.Notify != message.NotifyNobody {
+		msgUser = "<!" + string(msg.Notify) + "> " + msgUser
+	}
+	out.Text = msgUser + s.slackText(msg.Text)
+	out.Blocks = mention(msgUser, s.slackBlocks(msg.Blocks))
+
+	// A message that isn't part of a thread starts one
+	thread := out.ThreadTimestamp
+	if thread == "" {
+		thread = out.Timestamp
+	}
+	switch msg.Placement {
+	case message.InChannel:
+		out.ThreadTimestamp = ""
+	case message.InThread, message.Broadcast:
+		out.ThreadTimestamp = thread
+		out.ReplyBroadcast = msg.Placement == message.Broadcast
+	default:
+		if out.ThreadTimestamp == "" && !out.Direct && threadsIn(threads, out.Channel) {
+			out.ThreadTimestamp = thread
+		}
+	}
+	return out, true
+}
+
+// mention adds the mention to the start of a rich reply's first section,
+// or before its blocks if it doesn't start with one
+func mention(msgUser string, blocks []message.Block) []message.Block {
+	if len(blocks) == 0 {
+		return nil
+	}
+	if section, ok := blocks[0].(message.Section); ok && section.Text != "" {
+		section.Text = msgUser + section.Text
+		return append([]message.Block{section}, blocks[1:]...)
+	}
+	return append([]message.Block{message.Section{Text: msgUser}}, blocks...)
+}
+
+// threadsIn reports whether replies in the channel start threads
+func threadsIn(threads []string, channel string) bool {
+	for _, c := range threads {
+		if c == "*" || c == channel {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,464 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/message"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakeSlack serves enough of the Slack API for a Connection to connect
+type fakeSlack struct {
+	*httptest.Server
+
+	// rtmError is the error rtm.start answers with, and wsURL the
+	// websocket URL it hands out if it isn't the fake's own
+	rtmError, wsURL string
+
+	// allow lets rtm.start answer once. sessions receives each websocket
+	// the bot opens, which stays open until its done is closed.
+	allow    chan struct{}
+	sessions chan *fakeSession
+	quit     chan struct{}
+
+	// posts receives every message sent with chat.postMessage, and blocks
+	// their blocks
+	posts  chan Message
+	blocks chan string
+}
+
+type fakeSession struct {
+	ws   *websocket.Conn
+	done chan struct{}
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	f := &fakeSlack{
+		allow:    make(chan struct{}, 10),
+		sessions: make(chan *fakeSession),
+		quit:     make(chan struct{}),
+		posts:    make(chan Message, 10),
+		blocks:   make(chan string, 10),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "user_id": "UBOT"}`)
+	})
+	mux.HandleFunc("/users.info", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("user") != "U1" {
+			fmt.Fprint(w, `{"ok": false, "error": "user_not_found"}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok": true, "user": {"id": "U1", "name": "alice"}}`)
+	})
+	mux.HandleFunc("/users.list", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("cursor") != "page2" {
+			fmt.Fprint(w, `{"ok": true, "members": [{"id": "U1", "name": "alice", "tz": "America/New_York"}], "response_metadata": {"next_cursor": "page2"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok": true, "members": [
+			{"id": "U2", "name": "bob", "real_name": "Robert", "profile": {"display_name": "Bob"}},
+			{"id": "U3", "name": "bob", "deleted": true},
+			{"id": "UBOT", "name": "deckard", "is_bot": true}
+		], "response_metadata": {"next_cursor": ""}}`)
+	})
+	mux.HandleFunc("/conversations.list", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "channels": [{"id": "C1", "name": "general"}]}`)
+	})
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		var m Message
+		var blocks struct {
+			Blocks json.RawMessage `json:"blocks"`
+		}
+		if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			fmt.Fprint(w, `{"ok": false, "error": "not_authed"}`)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &m)
+		json.Unmarshal(body, &blocks)
+		f.posts <- m
+		f.blocks <- string(blocks.Blocks)
+		fmt.Fprint(w, `{"ok": true}`)
+	})
+	mux.HandleFunc("/rtm.start", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-f.allow:
+		case <-f.quit:
+			return
+		}
+		if f.rtmError != "" {
+			fmt.Fprintf(w, `{"ok": false, "error": %q}`, f.rtmError)
+			return
+		}
+		url := f.wsURL
+		if url == "" {
+			url = "ws" + strings.TrimPrefix(f.URL, "http") + "/ws"
+		}
+		fmt.Fprintf(w, `{"ok": true, "url": %q}`, url)
+	})
+	mux.Handle("/ws", websocket.Handler(func(ws *websocket.Conn) {
+		s := &fakeSession{ws: ws, done: make(chan struct{})}
+		select {
+		case f.sessions <- s:
+		case <-f.quit:
+			return
+		}
+		select {
+		case <-s.done:
+		case <-f.quit:
+		}
+	}))
+	f.Server = httptest.NewServer(mux)
+	config.SlackAPIURL = f.URL
+	return f
+}
+
+// Close stops the fake, hanging up any open websockets
+func (f *fakeSlack) Close() {
+	close(f.quit)
+	f.Server.Close()
+}
+
+func (f *fakeSlack) session(t *testing.T) *fakeSession {
+	f.allow <- struct{}{}
+	select {
+	case s := <-f.sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection didn't connect")
+		return nil
+	}
+}
+
+// send sends the event to the bot
+func (s *fakeSession) send(t *testing.T, event string) {
+	if err := websocket.Message.Send(s.ws, event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// message returns the next message the bot sent that isn't a ping
+func (s *fakeSession) message(t *testing.T) (m Message) {
+	s.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var raw json.RawMessage
+		if err := websocket.JSON.Receive(s.ws, &raw); err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal(raw, &m)
+		if m.Type != "ping" {
+			return m
+		}
+	}
+}
+
+func newTestConnection() *Connection {
+	s := NewConnection("xoxb-test")
+	s.MinBackoff = time.Millisecond
+	s.MaxBackoff = 10 * time.Millisecond
+	s.PingInterval = time.Hour
+	return s
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestConnection()
+	defer s.Close()
+
+	errs := make(chan error, 1)
+	rx, tx := s.Start(errs)
+	first := f.session(t)
+	first.send(t, `{"type": "message", "channel": "C1", "user": "U1", "text": "!ping", "ts": "1.0"}`)
+	var in message.Basic
+	select {
+	case in = <-rx:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	if in.Text != "!ping" || in.Sender.Name != "alice" {
+		t.Errorf("received %+v", in)
+	}
+
+	// The socket drops before the bot replies. The reply is sent once
+	// it's reconnected.
+	close(first.done)
+	waitFor(t, "the connection noticed", func() bool { return !s.Status().Connected })
+	tx <- message.Basic{ID: in.ID, Text: "pong"}
+	second := f.session(t)
+	if m := second.message(t); m.Text != "<@U1>: pong" || m.Channel != "C1" {
+		t.Errorf("reply after reconnecting = %+v", m)
+	}
+	tx <- message.Basic{ID: in.ID, Finished: true}
+	waitFor(t, "the inbox emptied", func() bool { return s.Status().Inbox == 0 })
+
+	// Slack saying goodbye reconnects too
+	second.send(t, `{"type": "goodbye"}`)
+	defer close(second.done)
+	third := f.session(t)
+	defer close(third.done)
+	waitFor(t, "connected", func() bool { return s.Status().Connected })
+	if status := s.Status(); status.Reconnects != 2 {
+		t.Errorf("reconnects = %d, want 2", status.Reconnects)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("the bot was told about a reconnect: %s", err)
+	default:
+	}
+}
+
+func TestReconnectMissedPongs(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestConnection()
+	s.PingInterval = 5 * time.Millisecond
+	s.PongTimeout = 20 * time.Millisecond
+	defer s.Close()
+
+	s.Start(make(chan error, 1))
+	first := f.session(t)
+	defer close(first.done)
+
+	// Slack never answers, so the bot hangs up after a few pings
+	first.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pings := 0
+	for {
+		var m Message
+		if err := websocket.JSON.Receive(first.ws, &m); err != nil {
+			break
+		}
+		if m.Type != "ping" {
+			t.Errorf("got %+v, want pings", m)
+		}
+		pings++
+	}
+	if pings < 2 {
+		t.Errorf("hung up after %d pings", pings)
+	}
+	second := f.session(t)
+	defer close(second.done)
+}
+
+func TestGiveUp(t *testing.T) {
+	for _, test := range []struct {
+		rtmError, wsURL string
+		err             string
+	}{
+		{rtmError: "invalid_auth", err: "after 1 attempts: Invalid authentication token."},
+		{wsURL: "ws://127.0.0.1:1/ws", err: "after 3 attempts"},
+	} {
+		f := newFakeSlack(t)
+		f.rtmError, f.wsURL = test.rtmError, test.wsURL
+		for i := 0; i < 3; i++ {
+			f.allow <- struct{}{}
+		}
+		s := newTestConnection()
+		s.MaxReconnects = 3
+
+		errs := make(chan error, 1)
+		s.Start(errs)
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error = %q, want it to mention %q", err, test.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%+v: the connection didn't give up", test)
+		}
+		if !s.Status().GaveUp {
+			t.Errorf("%+v: the status doesn't say the connection gave up", test)
+		}
+		if err := s.Post("C1", "hello"); err != errGaveUp {
+			t.Errorf("posting after giving up = %v, want %v", err, errGaveUp)
+		}
+		s.Close()
+		f.Close()
+	}
+}
+
+func TestReplyThreads(t *testing.T) {
+	s := NewConnection("xoxb-test")
+	threads := []string{"CTHREADS"}
+	for _, test := range []struct {
+		channel, thread string
+		direct          bool
+		placement       message.Placement
+		want            string
+		broadcast       bool
+	}{
+		{channel: "C1", want: ""},
+		{channel: "C1", thread: "1.0", want: "1.0"},
+		{channel: "CTHREADS", want: "2.0"},
+		{channel: "DTHREADS", direct: true, want: ""},
+		{channel: "C1", placement: message.InThread, want: "2.0"},
+		{channel: "C1", thread: "1.0", placement: message.InThread, want: "1.0"},
+		{channel: "CTHREADS", thread: "1.0", placement: message.InChannel, want: ""},
+		{channel: "C1", thread: "1.0", placement: message.Broadcast, want: "1.0", broadcast: true},
+	} {
+		in := Message{Channel: test.channel, User: "U1", Timestamp: "2.0", ThreadTimestamp: test.thread}
+		in.Direct = test.direct
+		s.Inbox[1] = in
+		out, ok := s.reply(message.Basic{ID: 1, Text: "pong", Placement: test.placement}, threads)
+		if !ok || out.ThreadTimestamp != test.want || out.ReplyBroadcast != test.broadcast {
+			t.Errorf("%+v: replied in thread %q broadcast %t", test, out.ThreadTimestamp, out.ReplyBroadcast)
+		}
+	}
+}
+
+func TestRichReply(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestConnection()
+	defer s.Close()
+
+	rx, tx := s.Start(make(chan error, 1))
+	session := f.session(t)
+	defer close(session.done)
+	session.send(t, `{"type": "message", "channel": "C1", "user": "U1", "text": "!issue 12", "ts": "1.0"}`)
+	var in message.Basic
+	select {
+	case in = <-rx:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	reply := message.Rich(
+		message.Section{Text: "*Issue #12*", Fields: []message.Field{{Title: "State", Value: "open"}}},
+		message.Image{URL: "https://example.com/graph.png", Title: "Burndown"},
+		message.Buttons{{Text: "View", URL: "https://example.com/12"}},
+		message.Divider{},
+		message.Context{"opened 2 days ago"},
+	)
+	reply.ID = in.ID
+	tx <- reply
+	var out Message
+	select {
+	case out = <-f.posts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rich reply wasn't posted")
+	}
+	blocks := <-f.blocks
+	if out.Channel != "C1" || out.Text != "<@U1>: *Issue #12*\nState: open\nBurndown: https://example.com/graph.png\nView: https://example.com/12\n---\nopened 2 days ago" {
+		t.Errorf("posted %+v", out)
+	}
+	want := `[{"type":"section","text":{"type":"mrkdwn","text":"\u003c@U1\u003e: *Issue #12*"},"fields":[{"type":"mrkdwn","text":"*State*\nopen"}]},` +
+		`{"type":"image","image_url":"https://example.com/graph.png","alt_text":"Burndown","title":{"type":"plain_text","text":"Burndown"}},` +
+		`{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"View"},"url":"https://example.com/12"}]},` +
+		`{"type":"divider"},` +
+		`{"type":"context","elements":[{"type":"mrkdwn","text":"opened 2 days ago"}]}]`
+	if blocks != want {
+		t.Errorf("blocks = %s\nwant %s", blocks, want)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestConnection()
+	if err := s.refreshDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, ok := s.directory.userNamed("BOB"); !ok || m.ID != "U2" || m.displayName() != "Bob" {
+		t.Errorf("bob = %+v, %t", m, ok)
+	}
+	if c, ok := s.directory.channelNamed("general"); !ok || c.ID != "C1" {
+		t.Errorf("#general = %+v, %t", c, ok)
+	}
+	if name := s.userName("U1"); name != "alice" {
+		t.Errorf("U1's name = %q", name)
+	}
+
+	s.directoryEvent("user_change", []byte(`{"type": "user_change", "user": {"id": "U2", "name": "robert"}}`))
+	s.directoryEvent("channel_created", []byte(`{"type": "channel_created", "channel": {"id": "C2", "name": "random"}}`))
+	if _, ok := s.directory.userNamed("bob"); ok {
+		t.Error("bob is still known by his old name")
+	}
+	if m, ok := s.directory.userNamed("robert"); !ok || m.ID != "U2" {
+		t.Errorf("robert = %+v, %t", m, ok)
+	}
+	if id, err := s.resolve("#random"); err != nil || id != "C2" {
+		t.Errorf("#random = %q, %v", id, err)
+	}
+	if _, err := s.resolve("#nowhere"); err == nil {
+		t.Error("posting to an unknown channel didn't fail")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestConnection()
+
+	// unknown users are looked up in the background
+	if name := s.userName("U1"); name != "U1" {
+		t.Errorf("U1's name before the lookup = %q", name)
+	}
+	waitFor(t, "U1 is looked up", func() bool { return s.userName("U1") == "alice" })
+
+	// and aren't asked about again for a while if they can't be
+	if name := s.userName("U9"); name != "U9" {
+		t.Errorf("U9's name = %q", name)
+	}
+	if s.directory.startLookup("U9", time.Now()) {
+		t.Error("U9 was looked up again straight away")
+	}
+	if !s.directory.startLookup("U9", time.Now().Add(lookupRetry)) {
+		t.Error("U9 was never looked up again")
+	}
+}
+
+func TestMentions(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestConnection()
+	if err := s.refreshDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	text, mentions := s.readableText("<@U2> and <@U9|carol>, see <#C1> and <#C9|random> <!here>")
+	if want := "@bob and @carol, see #general and #random @here"; text != want {
+		t.Errorf("incoming text = %q, want %q", text, want)
+	}
+	if bob := mentions["@bob"]; bob.ID != "U2" || bob.Name != "Bob" || bob.Mention != "<@U2>" {
+		t.Errorf("mentions = %+v", mentions)
+	}
+
+	for _, test := range []struct{ in, want string }{
+		{"@bob, see #general.", "<@U2>, see <#C1>."},
+		{"@nobody in #nowhere", "@nobody in #nowhere"},
+		{"@here `@bob` alice@example.com <@U1>", "@here `@bob` alice@example.com <@U1>"},
+		{"@channel lunch", "@channel lunch"},
+		{"https://example.com/#general", "https://example.com/#general"},
+	} {
+		if got := s.slackText(test.in); got != test.want {
+			t.Errorf("slackText(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+	// only a reply's Notify pings the channel
+	s.Inbox[1] = Message{Channel: "C1", User: "U1", Timestamp: "1.0"}
+	out, _ := s.reply(message.Basic{ID: 1, Text: "lunch", Notify: message.NotifyChannel}, nil)
+	if want := "<!channel> <@U1>: lunch"; out.Text != want {
+		t.Errorf("notifying reply = %q, want %q", out.Text, want)
+	}
+}
@@ -1,20 +1,25 @@
 package slack
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/log"
-
-	"golang.org/x/net/websocket"
 )
 
+// apiClient calls Slack's Web API. Its timeout stops a slow call from
+// holding up the connection for ever.
+var apiClient = &http.Client{Timeout: 10 * time.Second}
+
 var reSlackFormat = regexp.MustCompile(`<https?:\/\/(\S+)\|(\S+)>`)
 
 // formatSlackMsg remove automatic formatting done by Slack before it gets to the rx channel
@@ -37,6 +42,17 @@ func formatSlackMsg(msg string) string {
 	return fixed
 }
 
+// parseTimestamp converts a Slack message timestamp ("1355517523.000005")
+// into a time. Slack timestamps are seconds since the epoch, with the part
+// after the dot making the timestamp unique within a channel.
+func parseTimestamp(ts string) time.Time {
+	secs, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(secs*float64(time.Second)))
+}
+
 // messageIDGen creates a channel for generating the messageId needed
 // to send back a message
 func messageIDGen(start int, step int) <-chan int {
@@ -52,29 +68,16 @@ func messageIDGen(start int, step int) <-chan int {
 	return c
 }
 
-// keepalive is responsible for pinging the Slack websocket connection
-// in order to keep it alive. It will respond with `pong` if the ping was successful.
-func keepalive(ws *websocket.Conn, msgChan <-chan int) error {
-
-	for {
-		msgID := <-msgChan
-		err := websocket.JSON.Send(ws, struct {
-			ID   int    `json:"id"`
-			Type string `json:"type"`
-		}{ID: msgID, Type: "ping"})
-		if err != nil {
-			log.Error(err)
-			return err
-		}
-		// wait 15 second
-		time.Sleep(15 * time.Second)
-	}
+// authError is an error from Slack saying the token was rejected, so there's
+// no point trying again
+type authError struct {
+	error
 }
 
 // getWSSUrl returns the websocket url from a json payload
 // based on the supplied API auth token
 func getWSSUrl(token string) (string, error) {
-	resp, err := http.Get(config.SlackAPIURL + "/rtm.start?token=" + token)
+	resp, err := apiClient.Get(config.SlackAPIURL + "/rtm.start?token=" + token)
 	if err != nil {
 		return "", err
 	}
@@ -104,11 +107,11 @@ func getWSSUrl(token string) (string, error) {
 		case "migration_in_progress":
 			err = errors.New("Team is being migrated between servers.")
 		case "not_authed":
-			err = errors.New("No authentication token provided.")
+			err = authError{errors.New("No authentication token provided.")}
 		case "invalid_auth":
-			err = errors.New("Invalid authentication token.")
+			err = authError{errors.New("Invalid authentication token.")}
 		case "account_inactive":
-			err = errors.New("Authentication token is for a deleted user or team.")
+			err = authError{errors.New("Authentication token is for a deleted user or team.")}
 		default:
 			err = errors.New("Something else went wrong. rtm.start status not ok. See https://api.slack.com/methods/rtm.start")
 		}
@@ -126,7 +129,7 @@ func getWSSUrl(token string) (string, error) {
 // This is mostly used to get the username and id for the account associated with the API token
 func apiTokenAuthTest(token string) (botID string, err error) {
 
-	resp, err := http.Get(config.SlackAPIURL + "/auth.test?token=" + token)
+	resp, err := apiClient.Get(config.SlackAPIURL + "/auth.test?token=" + token)
 	if err != nil {
 		return
 	}
@@ -151,11 +154,11 @@ func apiTokenAuthTest(token string) (botID string, err error) {
 	if !authTestResp.Ok {
 		switch authTestResp.Error {
 		case "not_authed":
-			err = errors.New("No authentication token provided.")
+			err = authError{errors.New("No authentication token provided.")}
 		case "invalid_auth":
-			err = errors.New("Invalid authentication token.")
+			err = authError{errors.New("Invalid authentication token.")}
 		case "account_inactive":
-			err = errors.New("Authentication token is for a deleted user or team.")
+			err = authError{errors.New("Authentication token is for a deleted user or team.")}
 		default:
 			err = errors.New("Something else went wrong. auth.test status not ok. See https://api.slack.com/methods/auth.test")
 		}
@@ -165,87 +168,60 @@ func apiTokenAuthTest(token string) (botID string, err error) {
 	return
 }
 
-type userInfo struct {
-	Members []member
-	Ok      bool   `json:"ok"`
-	Error   string `json:"error"`
-}
-
-type member struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	IsBot bool   `json:"is_bot"`
-}
-
-type channelInfo struct {
-	Channels []channel
-	Ok       bool   `json:"ok"`
-	Error    string `json:"error"`
-}
-
-type channel struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-}
-
-// getUserById returns the Slack user Id based on the supplied username.
-// This is helpful because the RTM only sends the user Id, and sometimes
-// you'll need to convert that to the username.
-func (s *Connection) getUserByID(userName string) (string, error) {
-	resp, err := http.Get(config.SlackAPIURL + "/users.list?token=" + s.Token)
+// getUserInfo returns a Slack user from users.info
+func (s *Connection) getUserInfo(userID string) (member, error) {
+	var userResp struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+		User  member `json:"user"`
+	}
+	resp, err := apiClient.Get(config.SlackAPIURL + "/users.info?token=" + s.Token + "&user=" + url.QueryEscape(userID))
 	if err != nil {
-		return "", err
+		return userResp.User, err
 	}
 	defer resp.Body.Close()
 	raw, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return userResp.User, err
 	}
-	var userResp userInfo
 	err = json.Unmarshal(raw, &userResp)
 	if err != nil {
-		return "", err
+		return userResp.User, err
 	}
 
 	// Error reponses based on an ok: false
 	if !userResp.Ok {
 		switch userResp.Error {
 		case "user_not_found":
-			err = errors.New("Value passed for user was invalid.")
+			return userResp.User, errors.New("Value passed for user was invalid.")
 		case "user_not_visible":
-			err = errors.New("The requested user is not visible to the calling user")
-		case "not_authed":
-			err = errors.New("No authentication token provided.")
-		case "invalid_auth":
-			err = errors.New("Invalid authentication token.")
-		case "account_inactive":
-			err = errors.New("Authentication token is for a deleted user or team.")
+			return userResp.User, errors.New("The requested user is not visible to the calling user")
 		default:
-			err = errors.New("Something else went wrong. users.info status not ok. See https://api.slack.com/methods/users.info")
+			return userResp.User, errors.New("Something else went wrong. users.info status not ok. See https://api.slack.com/methods/users.info")
 		}
 	}
-	// loop through all members and return the user Id if the
-	// associated username matches the supplied username
-	var retValue string
-	user := userResp.Members
-	for i := 0; i < len(user); i++ {
-		item := user[i]
-		if item.Name == strings.ToLower(userName) {
-			retValue = item.ID
-		}
+	if userResp.User.ID == "" {
+		userResp.User.ID = userID
 	}
-	if retValue == "" {
-		err = errors.New("User not found!")
-	}
-	return retValue, err
+	return userResp.User, nil
+}
+
+// isUserID reports whether the ID is a Slack user ID rather than a channel
+func isUserID(id string) bool {
+	return strings.HasPrefix(id, "U") || strings.HasPrefix(id, "W")
 }
 
-// getChannelByName returns the Slack channel Id based on the supplied channel name.
-// This is helpful because the RTM can only send messages to a channel based on the
-// channel ID.
-func (s *Connection) getChannelByName(channel string) (string, error) {
+// dmChannel returns the ID of the direct message channel with the user,
+// opening it with im.open the first time
+func (s *Connection) dmChannel(userID string) (string, error) {
+	s.dmChannelsMu.Lock()
+	channel, ok := s.dmChannels[userID]
+	s.dmChannelsMu.Unlock()
+	if ok {
+		return channel, nil
+	}
 
-	resp, err := http.Get(config.SlackAPIURL + "/channels.list?token=" + s.Token)
+	resp, err := apiClient.Get(config.SlackAPIURL + "/im.open?token=" + s.Token + "&user=" + url.QueryEscape(userID))
 	if err != nil {
 		return "", err
 	}
@@ -254,37 +230,95 @@ func (s *Connection) getChannelByName(channel string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	var c channelInfo
-	err = json.Unmarshal(raw, &c)
+	var imResp struct {
+		Ok      bool   `json:"ok"`
+		Error   string `json:"error"`
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err = json.Unmarshal(raw, &imResp)
 	if err != nil {
 		return "", err
 	}
 
 	// Error reponses based on an ok: false
-	if !c.Ok {
-		switch c.Error {
-		case "not_authed":
-			err = errors.New("No authentication token provided.")
-		case "invalid_auth":
-			err = errors.New("Invalid authentication token.")
-		case "account_inactive":
-			err = errors.New("Authentication token is for a deleted user or team.")
+	if !imResp.Ok {
+		switch imResp.Error {
+		case "user_not_found":
+			return "", errors.New("Value passed for user was invalid.")
+		case "user_not_visible":
+			return "", errors.New("The requested user is not visible to the calling user")
+		case "user_disabled":
+			return "", errors.New("The user has been disabled.")
 		default:
-			err = errors.New("Something else went wrong. users.info status not ok. See https://api.slack.com/methods/users.info")
+			return "", errors.New("Something else went wrong. im.open status not ok. See https://api.slack.com/methods/im.open")
 		}
 	}
-	// loop through all members and return the user Id if the
-	// associated username matches the supplied username
-	var retValue string
-	channelList := c.Channels
-	for i := 0; i < len(channelList); i++ {
-		item := channelList[i]
-		if item.Name == strings.ToLower(channel) {
-			retValue = item.ID
-		}
+
+	s.dmChannelsMu.Lock()
+	s.dmChannels[userID] = imResp.Channel.ID
+	s.dmChannelsMu.Unlock()
+	return imResp.Channel.ID, nil
+}
+
+// postMessage sends the message's text, and blocks if it has any, to its
+// channel, and thread if it has one, with chat.postMessage
+func (s *Connection) postMessage(m Message) error {
+	var blocks []block
+	if len(m.Blocks) > 0 {
+		blocks = renderBlocks(m.Blocks)
+	}
+	body, err := json.Marshal(struct {
+		Channel         string  `json:"channel"`
+		Text            string  `json:"text"`
+		Blocks          []block `json:"blocks,omitempty"`
+		ThreadTimestamp string  `json:"thread_ts,omitempty"`
+		ReplyBroadcast  bool    `json:"reply_broadcast,omitempty"`
+	}{m.Channel, m.Text, blocks, m.ThreadTimestamp, m.ReplyBroadcast})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, config.SlackAPIURL+"/chat.postMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
 	}
-	if retValue == "" {
-		err = errors.New("Channel not found!")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var postResp struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	err = json.Unmarshal(raw, &postResp)
+	if err != nil {
+		return err
+	}
+
+	// Error reponses based on an ok: false
+	if !postResp.Ok {
+		switch postResp.Error {
+		case "channel_not_found":
+			return errors.New("Value passed for channel was invalid.")
+		case "not_in_channel":
+			return errors.New("The bot isn't in the channel.")
+		case "msg_too_long":
+			return errors.New("Message text is too long.")
+		case "invalid_blocks":
+			return errors.New("Slack couldn't show the message's blocks.")
+		case "ratelimited":
+			return errors.New("The bot is posting too many messages.")
+		default:
+			return errors.New("Something else went wrong. chat.postMessage status not ok. See https://api.slack.com/methods/chat.postMessage")
+		}
 	}
-	return retValue, err
+	return nil
 }
@@ -2,6 +2,7 @@ package slack
 
 import (
 	"fmt"
+	"time"
 )
 
 func ExampleformatSlackMsg() {
@@ -16,3 +17,12 @@ func ExampleformatSlackMsg() {
 	//
 	// <@U2934234|caitlin>
 }
+
+func ExampleparseTimestamp() {
+	fmt.Println(parseTimestamp("1355517523.000005").UTC().Format(time.RFC3339))
+	fmt.Println(parseTimestamp("").IsZero())
+
+	// Output:
+	// 2012-12-14T20:38:43Z
+	// true
+}
@@ -3,15 +3,33 @@ package stdio
 
 import (
 	"bufio"
+	"errors"
 	"os"
+	"os/user"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/connection"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// connectionName is set as the Connection on every message's envelope
+const connectionName = "stdio"
+
+func init() {
+	connection.Register("stdio", func(settings config.Section) (connection.Connection, error) {
+		if err := settings.Decode(&struct{}{}); err != nil {
+			return nil, err
+		}
+		return NewConnection(), nil
+	})
+}
+
 var (
 	colorRedBold = "\x1b[1;31m"
 	colorYellow  = "\x1b[0;33m"
@@ -20,42 +38,123 @@ var (
 
 // Connection provides an interface for storing the inbox for received messages via stdio connection type
 type Connection struct {
-	Inbox map[int]message.Basic
+	Inbox   map[int]message.Basic
+	inboxMu sync.Mutex
+
+	// posts are messages that aren't replies, written out by the TX goroutine
+	posts chan post
+
+	// status is reported by Status, and guarded by statusMu
+	status   connection.Status
+	statusMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewConnection creates a new StdIO object with an inbox to keep track of messages
 func NewConnection() *Connection {
 	s := &Connection{
-		make(map[int]message.Basic),
+		Inbox: make(map[int]message.Basic),
+		posts: make(chan post),
+		done:  make(chan struct{}),
 	}
 	return s
 }
 
+// Close stops the RX and TX goroutines. A read from stdin that is already
+// blocked can't be interrupted, so the RX goroutine exits after the next line.
+func (s *Connection) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.updateStatus(func(status *connection.Status) { status.Connected = false })
+	})
+	return nil
+}
+
 // Start creates two message channels to send and receive messages.
 // It will start two goroutines to listen and send on these channels
 func (s *Connection) Start(errorChannel chan error) (rx, tx message.BasicChannel) {
 	rx = make(message.BasicChannel)
 	tx = make(message.BasicChannel)
+	s.updateStatus(func(status *connection.Status) { status.Connected = true })
 	go s.startRX(rx, errorChannel)
 	go s.startTX(tx, errorChannel)
 	return rx, tx
 }
 
+// post is a message to write to stdout that isn't a reply
+type post struct {
+	channel, text string
+	sent          chan error
+}
+
+// Post writes the text to stdout, tagged with the channel
+func (s *Connection) Post(channel, text string) error {
+	p := post{channel: channel, text: text, sent: make(chan error, 1)}
+	select {
+	case s.posts <- p:
+	case <-s.done:
+		return errors.New("stdio connection is closed")
+	}
+	return <-p.sent
+}
+
+// Status reports whether stdin is still open, when the last line was read
+// and how many messages are waiting for a reply
+func (s *Connection) Status() connection.Status {
+	s.inboxMu.Lock()
+	inbox := len(s.Inbox)
+	s.inboxMu.Unlock()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	status := s.status
+	status.Inbox = inbox
+	return status
+}
+
+// updateStatus changes the status under its lock
+func (s *Connection) updateStatus(fn func(*connection.Status)) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	fn(&s.status)
+}
+
 // startRX will read lines off stdin and add them to the inbox and RX channel
 func (s *Connection) startRX(rx message.BasicChannel, errorChannel chan error) {
 	reader := bufio.NewReader(os.Stdin)
+	sender := localUser()
 	counter := 0
 	for {
 		line, err := reader.ReadString('\n')
 		line = strings.Trim(line, "\n")
 		// log.Debug("Got line: ", line)
 		if err != nil {
-			errorChannel <- err
+			s.updateStatus(func(status *connection.Status) { status.Connected = false })
+			select {
+			case errorChannel <- err:
+			case <-s.done:
+			}
 			break
 		}
+		s.updateStatus(func(status *connection.Status) { status.LastEvent = time.Now() })
 		msg := message.Basic{ID: counter, Text: line, Finished: false}
+		msg.Envelope = message.Envelope{
+			Sender:     sender,
+			Channel:    connectionName,
+			Timestamp:  time.Now(),
+			Connection: connectionName,
+			Direct:     true,
+			Raw:        line,
+		}
+		s.inboxMu.Lock()
 		s.Inbox[counter] = msg
-		rx <- msg
+		s.inboxMu.Unlock()
+		select {
+		case rx <- msg:
+		case <-s.done:
+			return
+		}
 		counter++
 	}
 }
@@ -65,27 +164,49 @@ func (s *Connection) startTX(tx message.BasicChannel, errorChannel chan error) {
 	writer := bufio.NewWriter(os.Stdout)
 	for {
 		select {
+		case <-s.done:
+			return
+		case p := <-s.posts:
+			p.sent <- write(writer, "DECKARD POST ("+p.channel+"): ", p.text)
 		case msg := <-tx:
 			if msg.Text != "" {
-				msgTTY := "DECKARD RESPONSE: " + msg.Text
-				if terminal.IsTerminal(int(os.Stdin.Fd())) {
-					msgTTY = colorRedBold + "DECKARD RESPONSE: " + colorYellow + msg.Text + colorReset
-				}
-				_, err := writer.Write([]byte(msgTTY + "\n\n"))
-				if err != nil {
-					errorChannel <- err
-					break
-				}
-				err = writer.Flush()
-				if err != nil {
+				if err := write(writer, "DECKARD RESPONSE: ", msg.Text); err != nil {
 					errorChannel <- err
 					break
 				}
 			}
+			s.inboxMu.Lock()
 			if msg.Finished {
 				delete(s.Inbox, msg.ID)
 			}
 			log.Debug("inbox size: ", len(s.Inbox))
+			s.inboxMu.Unlock()
 		}
 	}
 }
+
+// write writes the labelled text to the writer, in color on a terminal
+func write(writer *bufio.Writer, label, text string) error {
+	msgTTY := label + text
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		msgTTY = colorRedBold + label + colorYellow + text + colorReset
+	}
+	if _, err := writer.Write([]byte(msgTTY + "\n\n")); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// localUser returns the user running the bot, who is the sender
+// of every message read from stdin
+func localUser() message.User {
+	u, err := user.Current()
+	if err != nil {
+		return message.User{ID: os.Getenv("USER"), Name: os.Getenv("USER")}
+	}
+	name := u.Name
+	if name == "" {
+		name = u.Username
+	}
+	return message.User{ID: u.Username, Name: name}
+}
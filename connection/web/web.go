@@ -0,0 +1,285 @@
+// Package web is a Connection that takes messages over HTTP, so other
+// services and scripts can talk to the bot.
+//
+// Messages are POSTed as JSON, and the response holds every reply the
+// plugins sent:
+//
+//	curl -d '{"text": "!roll 2d6", "user": "ci"}' http://localhost:8080/
+//	{"replies":["You rolled 3, 5 for a total of 8"]}
+//
+// Without a Token, anyone who can reach the connection can claim to be any
+// user, so senders are marked unverified and can't use commands that need a
+// role. With one, requests must carry it as a bearer token:
+//
+//	curl -H 'Authorization: Bearer s3cret' -d '{"text": "!who", "user": "U024BE7LH"}' http://localhost:8080/
+//
+// Since the client names the user, the bot's per-user rate limit also
+// applies to each remote address, or to everyone with the Token.
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/connection"
+	"github.com/handwritingio/deckard-bot/log"
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// connectionName is set as the Connection on every message's envelope
+const connectionName = "web"
+
+// maxBody is the largest request body that's read
+const maxBody = 1 << 20
+
+func init() {
+	connection.Register("web", func(section config.Section) (connection.Connection, error) {
+		var settings struct {
+			Addr  string `yaml:"addr"`
+			Token string `yaml:"token"`
+		}
+		if err := section.Decode(&settings); err != nil {
+			return nil, err
+		}
+		if settings.Addr == "" {
+			return nil, errors.New("addr: web connections need an address to listen on, e.g. :8080")
+		}
+		conn := NewConnection(settings.Addr)
+		conn.Token = settings.Token
+		return conn, nil
+	})
+}
+
+// Request is the JSON body of a message POSTed to the bot
+type Request struct {
+	Text    string `json:"text"`
+	User    string `json:"user"`
+	Channel string `json:"channel"`
+}
+
+// Response is the JSON body sent back once the bot has finished replying
+type Response struct {
+	Replies []string `json:"replies"`
+}
+
+// Connection serves HTTP requests and passes them to the bot. It's an
+// http.Handler, so it can also be mounted on an existing server.
+type Connection struct {
+	// Addr is the address to listen on. If it's empty, Start doesn't listen
+	// and the Connection should be served by something else.
+	Addr string
+
+	// Token is a shared secret every request must send in its Authorization
+	// header, as "Bearer <token>". If it's empty, requests aren't
+	// authenticated and their senders are unverified.
+	Token string
+
+	rx message.BasicChannel
+
+	mu      sync.Mutex
+	counter int
+	pending map[int]*pendingRequest
+
+	listener  net.Listener
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// pendingRequest collects the replies to a message until it's finished
+type pendingRequest struct {
+	replies chan message.Basic
+	gone    chan struct{}
+}
+
+// NewConnection creates a web connection that listens on addr
+func NewConnection(addr string) *Connection {
+	return &Connection{
+		Addr:    addr,
+		rx:      make(message.BasicChannel),
+		pending: make(map[int]*pendingRequest),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start creates two message channels to send and receive messages, and
+// starts listening on Addr if it's set
+func (s *Connection) Start(errorChannel chan error) (rx, tx message.BasicChannel) {
+	tx = make(message.BasicChannel)
+	go s.startTX(tx)
+
+	if s.Addr != "" {
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			errorChannel <- err
+			return s.rx, tx
+		}
+		s.mu.Lock()
+		s.listener = ln
+		s.mu.Unlock()
+		go func() {
+			err := http.Serve(ln, s)
+			select {
+			case <-s.done:
+			case errorChannel <- err:
+			}
+		}()
+		log.Infof("Listening for messages on %s", ln.Addr())
+	}
+	return s.rx, tx
+}
+
+// Close stops listening and answers any waiting requests
+func (s *Connection) Close() (err error) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+	})
+	return
+}
+
+// startTX hands each reply to the request it answers
+func (s *Connection) startTX(tx message.BasicChannel) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-tx:
+			s.mu.Lock()
+			p, ok := s.pending[msg.ID]
+			s.mu.Unlock()
+			if !ok {
+				log.Debugf("Dropping reply to finished request %d", msg.ID)
+				continue
+			}
+			select {
+			case p.replies <- msg:
+			case <-p.gone:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// ServeHTTP sends a POSTed message to the bot and responds with its replies
+func (s *Connection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "messages must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Token != "" && !s.authenticated(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	p := &pendingRequest{
+		replies: make(chan message.Basic),
+		gone:    make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.counter++
+	id := s.counter
+	s.pending[id] = p
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+		close(p.gone)
+	}()
+
+	channel := req.Channel
+	if channel == "" {
+		channel = connectionName
+	}
+	msg := message.Basic{ID: id, Text: req.Text}
+	msg.Envelope = message.Envelope{
+		Sender: message.User{
+			ID:         req.User,
+			Name:       req.User,
+			Mention:    "@" + req.User,
+			Unverified: s.Token == "",
+		},
+		Channel:    channel,
+		Timestamp:  time.Now(),
+		Connection: connectionName,
+		Origin:     s.origin(r),
+		Direct:     req.Channel == "",
+		Raw:        req,
+	}
+
+	select {
+	case s.rx <- msg:
+	case <-r.Context().Done():
+		return
+	case <-s.done:
+		http.Error(w, "the bot is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := Response{Replies: []string{}}
+	for finished := false; !finished; {
+		select {
+		case reply := <-p.replies:
+			if reply.Text != "" {
+				resp.Replies = append(resp.Replies, reply.Text)
+			}
+			finished = reply.Finished
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			finished = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Warnf("Error writing web response: %s", err.Error())
+	}
+}
+
+// origin is who sent the request, since anyone can claim to be any user:
+// everyone with the Token, or else the remote address
+func (s *Connection) origin(r *http.Request) string {
+	if s.Token != "" {
+		return "token"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authenticated reports whether the request carries the connection's Token
+func (s *Connection) authenticated(r *http.Request) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	token := strings.TrimPrefix(auth, prefix)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
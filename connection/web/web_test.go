@@ -0,0 +1,77 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+func ExampleConnection() {
+	conn := NewConnection("")
+	rx, tx := conn.Start(make(chan error, 1))
+	defer conn.Close()
+
+	// Stand in for the bot, shouting every message back
+	go func() {
+		for in := range rx {
+			tx <- message.Basic{ID: in.ID, Text: strings.ToUpper(in.Text)}
+			tx <- message.Basic{ID: in.ID, Text: "from " + in.Sender.Name + " at " + in.Origin + " over " + in.Connection}
+			tx <- message.Basic{ID: in.ID, Text: fmt.Sprintf("verified: %t", !in.Sender.Unverified)}
+			tx <- message.Basic{ID: in.ID, Finished: true}
+		}
+	}()
+
+	server := httptest.NewServer(conn)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"text": "hello", "user": "ci"}`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Print(string(body))
+	// Output:
+	// {"replies":["HELLO","from ci at 127.0.0.1 over web","verified: false"]}
+}
+
+func ExampleConnection_token() {
+	conn := NewConnection("")
+	conn.Token = "s3cret"
+	rx, tx := conn.Start(make(chan error, 1))
+	defer conn.Close()
+
+	go func() {
+		for in := range rx {
+			tx <- message.Basic{ID: in.ID, Text: fmt.Sprintf("%s verified: %t", in.Sender.Name, !in.Sender.Unverified)}
+			tx <- message.Basic{ID: in.ID, Finished: true}
+		}
+	}()
+
+	server := httptest.NewServer(conn)
+	defer server.Close()
+
+	for _, token := range []string{"", "guess", "s3cret"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"text": "hello", "user": "ci"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Print(resp.StatusCode, " ", string(body))
+	}
+	// Output:
+	// 401 a valid bearer token is required
+	// 401 a valid bearer token is required
+	// 200 {"replies":["ci verified: true"]}
+}
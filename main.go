@@ -1,32 +1,37 @@
 /*
-This is a sample main.go file which includes setup for a stdio connection
-and plugins that do not require any configurations. If you'd like to build
-your own plugins or connections, see our DEVELOP.md documentation
+This is a sample main.go file which runs the bot described by its configuration
+file, deckard.yml, or the file named by DECKARD_CONFIG. With no configuration
+file it talks over stdio to the plugins that do not require any configuration.
+If you'd like to build your own plugins or connections, see our DEVELOP.md
+documentation
 */
 package main
 
 import (
 	"github.com/handwritingio/deckard-bot/bot"
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/log"
 
-	"github.com/handwritingio/deckard-bot/plugins/cats"
-	"github.com/handwritingio/deckard-bot/plugins/dice"
-	"github.com/handwritingio/deckard-bot/plugins/principles"
-	"github.com/handwritingio/deckard-bot/plugins/tableflip"
-
-	"github.com/handwritingio/deckard-bot/connection/stdio"
+	// Importing a connection's or plugin's package makes it available to
+	// the configuration file
+	_ "github.com/handwritingio/deckard-bot/connection/slack"
+	_ "github.com/handwritingio/deckard-bot/connection/stdio"
+	_ "github.com/handwritingio/deckard-bot/connection/web"
+	_ "github.com/handwritingio/deckard-bot/plugins/standard"
 )
 
 func main() {
-	// 1. Setup a new connection
-	conn := stdio.NewConnection()
+	// 1. Load the configuration file
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// 2. Create the bot using the connection and a list of plugins
-	deckard := bot.New("Deckard", conn,
-		&dice.Plugin{},
-		&tableflip.Plugin{},
-		&cats.Plugin{},
-		&principles.Plugin{},
-	)
+	// 2. Create the bot with the connections and plugins it describes
+	deckard, err := bot.FromConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// 3. Start the bot!
 	deckard.Go()
@@ -2,14 +2,125 @@
 // are allowed to be sent through the RX and TX channels.
 package message
 
+import "time"
+
 // Basic implements the message structure that is added to the inbox and
 // sent to the plugins
 type Basic struct {
 	ID       int    `json:"id"`
 	Text     string `json:"text"`
 	Finished bool
+
+	// Placement says where a reply goes on connections with threads. It's
+	// ignored on incoming messages and by connections without threads.
+	Placement Placement `json:"-"`
+
+	// Notify pings everyone in the channel with a reply, on connections
+	// that can. Writing "@here" in Text doesn't, so replies that echo what
+	// somebody typed can't ping a whole channel.
+	Notify Notify `json:"-"`
+
+	// Blocks make up a rich reply, which connections that can show them use
+	// instead of Text. Text should still be set, to their PlainText, for
+	// everywhere else. See Rich.
+	Blocks []Block `json:"-"`
+
+	// Envelope describes where an incoming message came from. Connections
+	// fill it in before the message reaches the RX channel. It's never
+	// serialized, so connections can embed Basic in their wire format.
+	Envelope `json:"-"`
 }
 
+// Envelope holds everything a connection knows about who sent a message
+// and where it was sent
+type Envelope struct {
+	// Sender is the person who sent the message
+	Sender User
+
+	// Channel is the connection's identifier for the channel or room
+	// the message was sent in
+	Channel string
+
+	// Thread identifies the thread the message was sent in, if the
+	// connection supports threads and the message was part of one
+	Thread string
+
+	// Timestamp is when the message was sent
+	Timestamp time.Time
+
+	// Connection is the name of the connection the message arrived on
+	Connection string
+
+	// Origin identifies the client that sent the message, on connections
+	// where the client names the sender itself, e.g. a web request's remote
+	// address. The sender's rate limit also applies to each origin, so a
+	// client can't escape it by changing the name it gives.
+	Origin string
+
+	// Direct is true when the message was sent directly to the bot
+	// rather than in a channel shared with other people
+	Direct bool
+
+	// Mentions are the users mentioned in the message, by how they're
+	// written in its Text, e.g. "@bob", on connections that turn their own
+	// markup for mentions into names
+	Mentions map[string]User
+
+	// Raw is the message exactly as the connection received it, for plugins
+	// that need something the envelope doesn't carry. Its type depends on
+	// the connection.
+	Raw interface{}
+}
+
+// User identifies the sender of a message
+type User struct {
+	// ID is the connection's unique identifier for the user
+	ID string
+
+	// Name is the user's display name
+	Name string
+
+	// Mention is how to mention the user in a message sent on their
+	// connection, e.g. "<@U024BE7LH>" on Slack. It's empty if the
+	// connection doesn't have mentions.
+	Mention string
+
+	// Unverified is set when the connection can't vouch that the user is
+	// who they say, e.g. an unauthenticated web request. Unverified users
+	// have no roles.
+	Unverified bool
+
+	// TimeZone is the IANA name of the user's time zone, e.g.
+	// "America/New_York", on connections that know it
+	TimeZone string
+}
+
+// Placement is where a reply is sent, relative to the thread the message
+// it answers was part of
+type Placement string
+
+// By default a reply goes in the thread its message was part of, or
+// wherever the connection is configured to reply. A plugin can instead reply
+// in a thread on the message, starting one if need be, in the channel outside
+// any thread, or in the thread with a copy shown in the channel too.
+const (
+	DefaultPlacement Placement = ""
+	InThread         Placement = "thread"
+	InChannel        Placement = "channel"
+	Broadcast        Placement = "broadcast"
+)
+
+// Notify is who a reply pings, besides the people it mentions
+type Notify string
+
+// A reply pings nobody else by default. It can instead ping everyone in
+// the channel who's active, or everyone in the channel.
+const (
+	NotifyNobody  Notify = ""
+	NotifyHere    Notify = "here"
+	NotifyChannel Notify = "channel"
+)
+
 // BasicChannel is a channel that accepts Basic messages.
 // All transmit and receive channels must fit this type
 type BasicChannel chan Basic
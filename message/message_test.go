@@ -17,3 +17,44 @@ func Example() {
 	// 2
 	// true
 }
+
+func ExampleEnvelope() {
+	m := Basic{
+		ID:   3,
+		Text: "!who",
+		Envelope: Envelope{
+			Sender:     User{ID: "U024BE7LH", Name: "caitlin"},
+			Channel:    "C024BE91L",
+			Connection: "slack",
+		},
+	}
+
+	fmt.Println(m.Sender.Name)
+	fmt.Println(m.Channel)
+	fmt.Println(m.Direct)
+
+	// Output:
+	// caitlin
+	// C024BE91L
+	// false
+}
+
+func ExampleRich() {
+	out := Rich(
+		Section{Text: "*Issue #12*: Deckard forgets reminders", Fields: []Field{
+			{Title: "State", Value: "open"},
+			{Title: "Assignee", Value: "caitlin"},
+		}},
+		Buttons{{Text: "View on GitHub", URL: "https://github.com/handwritingio/deckard-bot/issues/12"}},
+		Context{"handwritingio/deckard-bot", "opened 2 days ago"},
+	)
+
+	fmt.Println(out.Text)
+
+	// Output:
+	// *Issue #12*: Deckard forgets reminders
+	// State: open
+	// Assignee: caitlin
+	// View on GitHub: https://github.com/handwritingio/deckard-bot/issues/12
+	// handwritingio/deckard-bot | opened 2 days ago
+}
@@ -0,0 +1,106 @@
+package message
+
+import "strings"
+
+// Block is part of a rich reply. Connections that can, like Slack, show
+// each kind of block in its own way, and everywhere else a reply is shown
+// as the PlainText of its blocks.
+type Block interface {
+	// PlainText is the block written out as readable text
+	PlainText() string
+}
+
+// Section is a paragraph of text, with optional fields shown side by side
+// beneath it. Text may use Slack-style formatting, e.g. *bold* and `code`.
+type Section struct {
+	Text   string
+	Fields []Field
+}
+
+// Field is a labelled value in a Section
+type Field struct {
+	Title string
+	Value string
+}
+
+// Image shows the picture at URL. Alt describes it for anyone who can't
+// see it.
+type Image struct {
+	URL   string
+	Alt   string
+	Title string
+}
+
+// Buttons are links shown as a row of buttons
+type Buttons []Button
+
+// Button links to URL
+type Button struct {
+	Text string
+	URL  string
+}
+
+// Context is small print, like a footer, with each part shown side by side
+type Context []string
+
+// Divider separates the blocks before it from those after
+type Divider struct{}
+
+// PlainText writes the section's text with each field on its own line
+func (s Section) PlainText() string {
+	lines := []string{}
+	if s.Text != "" {
+		lines = append(lines, s.Text)
+	}
+	for _, f := range s.Fields {
+		lines = append(lines, f.Title+": "+f.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PlainText writes the image's title, or description, and its URL
+func (i Image) PlainText() string {
+	if i.Title != "" {
+		return i.Title + ": " + i.URL
+	}
+	if i.Alt != "" {
+		return i.Alt + ": " + i.URL
+	}
+	return i.URL
+}
+
+// PlainText writes each button's text and link on its own line
+func (b Buttons) PlainText() string {
+	lines := []string{}
+	for _, button := range b {
+		lines = append(lines, button.Text+": "+button.URL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PlainText writes the parts of the context separated by bars
+func (c Context) PlainText() string {
+	return strings.Join(c, " | ")
+}
+
+// PlainText writes a line
+func (Divider) PlainText() string {
+	return "---"
+}
+
+// PlainText writes out the blocks, each on its own line
+func PlainText(blocks []Block) string {
+	lines := []string{}
+	for _, b := range blocks {
+		if text := b.PlainText(); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Rich returns a reply made of the blocks, with its Text set to their
+// PlainText for connections that can't show them
+func Rich(blocks ...Block) Basic {
+	return Basic{Text: PlainText(blocks), Blocks: blocks}
+}
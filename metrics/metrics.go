@@ -0,0 +1,261 @@
+/*
+Package metrics keeps counters, gauges and histograms and serves them in the
+Prometheus text format (https://prometheus.io/docs/instrumenting/exposition_formats/),
+so Prometheus can scrape the bot.
+
+Metrics are created on a Registry, which is an http.Handler for the
+/metrics endpoint. Each metric has a fixed list of label names, and a
+value is given for each when it's updated
+
+ r := metrics.NewRegistry()
+ received := r.Counter("deckard_messages_received_total", "Messages received", "connection")
+ received.Inc("slack")
+ http.Handle("/metrics", r)
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets a
+// Histogram counts observations into if it isn't given any
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Registry holds a set of metrics
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	scrapes  []func()
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// OnScrape adds a function that's called each time the metrics are written,
+// before they are. It's the place to set gauges that are read from elsewhere,
+// like the size of a queue.
+func (r *Registry) OnScrape(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scrapes = append(r.scrapes, fn)
+}
+
+// add registers a new metric. Names must be unique.
+func (r *Registry) add(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == f.name {
+			panic("metrics: " + f.name + " is already registered")
+		}
+	}
+	f.series = make(map[string]*series)
+	r.families = append(r.families, f)
+	return f
+}
+
+// Counter registers a counter, a value that only goes up
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.add(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Gauge registers a gauge, a value that can go up and down
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.add(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// Histogram registers a histogram, which counts observations into buckets
+// by their upper bounds. If buckets is nil DefaultBuckets are used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.add(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+// Write writes every metric in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	scrapes := append([]func(){}, r.scrapes...)
+	families := append([]*family{}, r.families...)
+	r.mu.Unlock()
+	for _, fn := range scrapes {
+		fn()
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// family is a metric and all of its series
+type family struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mu     sync.Mutex
+	series map[string]*series // by label values
+}
+
+// series is the value of a metric for one set of label values
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64 // per bucket, for histograms
+	count  uint64
+}
+
+// get returns the series for the label values, creating it if needed.
+// f.mu must be held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s needs %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.Replace(f.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelText(f.labels, s.labels), formatFloat(s.value))
+			continue
+		}
+		names := append(append([]string(nil), f.labels...), "le")
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			values := append(append([]string(nil), s.labels...), formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(names, values), cumulative)
+		}
+		values := append(append([]string(nil), s.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelText(f.labels, s.labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelText(f.labels, s.labels), s.count)
+	}
+}
+
+// labelText formats the labels as {name="value",...}
+func labelText(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, like the number of messages received
+type Counter struct {
+	f *family
+}
+
+// Inc adds one to the counter for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters can't go down")
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labelValues).value += v
+}
+
+// Gauge is a value that can go up and down, like the size of a queue
+type Gauge struct {
+	f *family
+}
+
+// Set sets the gauge for the label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(labelValues).value = v
+}
+
+// Reset forgets every set of label values, e.g. before setting the gauge
+// afresh for whatever exists now
+func (g *Gauge) Reset() {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.series = make(map[string]*series)
+}
+
+// Histogram counts observations into buckets, like how long requests take
+type Histogram struct {
+	f *family
+}
+
+// Observe counts v for the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.value += v
+	s.count++
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import "os"
+
+func ExampleRegistry() {
+	r := NewRegistry()
+	received := r.Counter("messages_received_total", "Messages received", "connection")
+	inbox := r.Gauge("inbox_size", "Messages waiting for a reply")
+	latency := r.Histogram("plugin_duration_seconds", "How long plugins take", []float64{0.1, 1}, "plugin")
+
+	received.Inc("slack")
+	received.Add(2, `say "hi"`)
+	r.OnScrape(func() { inbox.Set(3) })
+	latency.Observe(0.05, "Dice")
+	latency.Observe(0.5, "Dice")
+	latency.Observe(2, "Dice")
+
+	r.Write(os.Stdout)
+	// Output:
+	// # HELP messages_received_total Messages received
+	// # TYPE messages_received_total counter
+	// messages_received_total{connection="say \"hi\""} 2
+	// messages_received_total{connection="slack"} 1
+	// # HELP inbox_size Messages waiting for a reply
+	// # TYPE inbox_size gauge
+	// inbox_size 3
+	// # HELP plugin_duration_seconds How long plugins take
+	// # TYPE plugin_duration_seconds histogram
+	// plugin_duration_seconds_bucket{plugin="Dice",le="0.1"} 1
+	// plugin_duration_seconds_bucket{plugin="Dice",le="1"} 2
+	// plugin_duration_seconds_bucket{plugin="Dice",le="+Inf"} 3
+	// plugin_duration_seconds_sum{plugin="Dice"} 2.55
+	// plugin_duration_seconds_count{plugin="Dice"} 3
+}
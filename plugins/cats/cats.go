@@ -7,66 +7,78 @@ import (
 	"io/ioutil"
 	"net/http"
 	"regexp"
-	"strings"
 
+	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
 )
 
 // Plugin ...
 type Plugin struct{}
 
-var (
-	// reCats is the regexp variables for logic in HandleMessage
-	reCats     = regexp.MustCompile("(?i)^!cat")
-	reCatsType = regexp.MustCompile("(?i)^!cat (\\w+)$")
+func init() {
+	plugins.Register("cats", func(settings config.Section) (plugins.Plugin, error) {
+		p := &Plugin{}
+		return p, settings.Decode(p)
+	})
+}
 
+var (
 	catImgURL  = "http://thecatapi.com/api/images/get?format=src&size=med&type="
 	catFactURL = "https://catfact.ninja/fact"
+
+	commands = plugins.NewRouter(&plugins.Command{
+		Name: "!cat",
+		Subcommands: []*plugins.Command{
+			{
+				Name:        "image",
+				Description: "to generate new cat photo",
+				Handler:     catHandler("image", func() string { return getCatImage("jpg") }),
+			},
+			{
+				Name:        "gif",
+				Description: "to generate new cat gif",
+				Handler:     catHandler("gif", func() string { return getCatImage("gif") }),
+			},
+			{
+				Name:        "fact",
+				Description: "to generate new cat fact",
+				Handler:     catHandler("fact", getCatFact),
+			},
+		},
+	})
 )
 
 // Command returns a list of commands the plugin provides
 func (p Plugin) Command() []string {
-	return []string{"!cat"}
+	return commands.Command()
 }
 
 // Usage prints detailed usage instructions for the plugin
 func (p Plugin) Usage() string {
-	return "`!cat image` to generate new cat photo\n" +
-		"`!cat gif` to generate new cat gif\n" +
-		"`!cat fact` to generate new cat fact"
+	return commands.Usage()
 }
 
 // Regexp returns the regexp of a message that should be handled by this plugin
 func (p Plugin) Regexp() *regexp.Regexp {
-	return reCats
+	return commands.Regexp()
 }
 
 // HandleMessage is responsible for handling the incoming message
 // and returning a response based on the message provides
 func (p Plugin) HandleMessage(in message.Basic) (out message.Basic) {
-	chunks := reCatsType.FindStringSubmatch(in.Text)
-	log.Debug("Arguments: ", len(chunks))
-	// Define the option and argument strings, if supplied
-	var cmd string
-	if len(chunks) > 1 {
-		cmd = strings.ToLower(chunks[1])
+	return commands.HandleMessage(in)
+}
 
+// catHandler returns a command handler that replies with whatever get
+// retrieves, or an apology if it couldn't retrieve anything
+func catHandler(cmd string, get func() string) func(message.Basic, plugins.Args) message.Basic {
+	return func(in message.Basic, args plugins.Args) (out message.Basic) {
 		log.Debugf("Cat cmd: %s\n", cmd)
+		out.Text = checkResponse(cmd, get())
+		return
 	}
-
-	switch cmd {
-	case "gif":
-		out.Text = checkResponse(cmd, getCatImage("gif"))
-	case "image":
-		out.Text = checkResponse(cmd, getCatImage("jpg"))
-	case "fact":
-		out.Text = checkResponse(cmd, getCatFact())
-	default:
-		out.Text = p.Usage()
-	}
-
-	return
 }
 
 func checkResponse(cmd, text string) string {
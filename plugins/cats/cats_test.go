@@ -2,16 +2,26 @@ package cats
 
 import (
 	"fmt"
+
+	"github.com/handwritingio/deckard-bot/message"
 )
 
 func ExamplePlugin_HandleMessage() {
-	fmt.Printf("%q\n", reCats.FindStringSubmatch("!cat"))
-	fmt.Printf("%q\n", reCatsType.FindStringSubmatch("!cat fact")[1])
-	fmt.Printf("%q\n", reCatsType.FindStringSubmatch("!cat gif"))
-	fmt.Printf("%q\n", reCats.FindStringSubmatch("cat"))
+	p := Plugin{}
+	fmt.Println(p.Regexp().MatchString("!cat"))
+	fmt.Println(p.Regexp().MatchString("!cat fact"))
+	fmt.Println(p.Regexp().MatchString("cat"))
+	fmt.Println(p.HandleMessage(message.Basic{Text: "!cat"}).Text)
+	fmt.Println(p.HandleMessage(message.Basic{Text: "!cat dog"}).Text)
 	// Output:
-	// ["!cat"]
-	// "fact"
-	// ["!cat gif" "gif"]
-	// []
+	// true
+	// true
+	// false
+	// `!cat image` to generate new cat photo
+	// `!cat gif` to generate new cat gif
+	// `!cat fact` to generate new cat fact
+	// Sorry, `dog` is not a subcommand of `!cat`
+	// `!cat image` to generate new cat photo
+	// `!cat gif` to generate new cat gif
+	// `!cat fact` to generate new cat fact
 }
@@ -0,0 +1,538 @@
+package plugins
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// ArgType is the type an argument's value is parsed as
+type ArgType int
+
+const (
+	// StringArg is a single word, or several words in double quotes
+	StringArg ArgType = iota
+
+	// IntArg is a whole number
+	IntArg
+
+	// BoolArg is only used for flags. The flag is true when it's given
+	// on its own, or it can be set explicitly with --flag=false
+	BoolArg
+
+	// TextArg is the rest of the message exactly as it was typed.
+	// It can only be used for the last positional argument.
+	TextArg
+)
+
+// Arg describes a positional argument or a flag of a Command
+type Arg struct {
+	// Name is shown in the usage and is used to look the value up in Args.
+	// Flags are given in a message as --name.
+	Name string
+
+	// Type is how the value is parsed. It defaults to StringArg
+	Type ArgType
+
+	// Description is shown under the command in the usage
+	Description string
+
+	// Optional positional arguments may be left off the end of the command.
+	// Flags are always optional.
+	Optional bool
+
+	// Default is the value used when an optional argument or flag isn't given
+	Default string
+
+	// Pattern, if set, must match the value for the message to be accepted
+	Pattern *regexp.Regexp
+}
+
+// Command declares a command, its arguments and the function that handles it.
+// A command with Subcommands routes the next word of the message to the
+// matching subcommand. If it has no handler of its own, the usage is sent
+// back when no subcommand is given.
+type Command struct {
+	// Name is the word that triggers the command, e.g. "!dice" for a
+	// top-level command or "gif" for a subcommand
+	Name string
+
+	// Aliases are other names the command answers to
+	Aliases []string
+
+	// Description is shown next to the command in the usage
+	Description string
+
+	// Args are the positional arguments, in order
+	Args []Arg
+
+	// Flags are the --flag arguments, which may be given anywhere
+	// before a TextArg
+	Flags []Arg
+
+	// Subcommands of this command
+	Subcommands []*Command
+
+	// Handler is called with the parsed arguments and returns the reply
+	Handler func(in message.Basic, args Args) message.Basic
+
+	// StreamHandler is used instead of Handler when the command needs to
+	// send more than one reply
+	StreamHandler func(in message.Basic, args Args, r Responder)
+
+	// Role is the role a user needs to run the command and its subcommands,
+	// unless a subcommand declares a role of its own. Empty means anyone may.
+	// The bot only checks it for plugins that implement RestrictedPlugin,
+	// which Router.Role makes easy.
+	Role string
+}
+
+// matches reports whether word is the command's name or one of its aliases
+func (c *Command) matches(word string) bool {
+	if strings.EqualFold(word, c.Name) {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if strings.EqualFold(word, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Command) handles() bool {
+	return c.Handler != nil || c.StreamHandler != nil
+}
+
+func (c *Command) flag(name string) *Arg {
+	for i := range c.Flags {
+		if strings.EqualFold(c.Flags[i].Name, name) {
+			return &c.Flags[i]
+		}
+	}
+	return nil
+}
+
+// Args holds the parsed arguments and flags of a message, by name
+type Args struct {
+	values map[string]interface{}
+	given  map[string]bool
+}
+
+// String returns the argument as it was typed. It's empty if the
+// argument wasn't given and has no default.
+func (a Args) String(name string) string {
+	switch v := a.values[name].(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// Int returns an IntArg argument, or 0 if it wasn't given and has no default
+func (a Args) Int(name string) int {
+	v, _ := a.values[name].(int)
+	return v
+}
+
+// Bool returns a BoolArg flag, or false if it wasn't given and has no default
+func (a Args) Bool(name string) bool {
+	v, _ := a.values[name].(bool)
+	return v
+}
+
+// Has reports whether the argument was given in the message
+func (a Args) Has(name string) bool {
+	return a.given[name]
+}
+
+// ArgumentError is returned when a message doesn't fit the declaration of
+// the command it triggered
+type ArgumentError struct {
+	// Command is the full command that was matched, e.g. "!cat gif"
+	Command string
+
+	// Reason explains what was wrong. It's empty when the command needs a
+	// subcommand and none was given.
+	Reason string
+}
+
+func (e *ArgumentError) Error() string {
+	if e.Reason == "" {
+		return "`" + e.Command + "` needs a subcommand"
+	}
+	return e.Reason
+}
+
+// Router matches messages against a set of commands, parses their arguments
+// and calls the command's handler. A plugin can hand its Regexp, Command,
+// Usage, HandleMessage and HandleMessageStream methods straight to a Router,
+// so the commands it answers to and the usage shown by !help always agree.
+type Router struct {
+	commands []*Command
+	re       *regexp.Regexp
+}
+
+// NewRouter returns a Router for the top-level commands
+func NewRouter(commands ...*Command) *Router {
+	var names []string
+	for _, c := range commands {
+		names = append(names, regexp.QuoteMeta(c.Name))
+		for _, alias := range c.Aliases {
+			names = append(names, regexp.QuoteMeta(alias))
+		}
+	}
+	return &Router{
+		commands: commands,
+		re:       regexp.MustCompile(`(?i)^(?:` + strings.Join(names, "|") + `)(?:\s|$)`),
+	}
+}
+
+// Regexp matches any message that starts with one of the router's commands
+func (r *Router) Regexp() *regexp.Regexp {
+	return r.re
+}
+
+// Command returns the names of the top-level commands
+func (r *Router) Command() (names []string) {
+	for _, c := range r.commands {
+		names = append(names, c.Name)
+	}
+	return
+}
+
+// Usage describes every command, its arguments and flags
+func (r *Router) Usage() string {
+	var lines []string
+	for _, c := range r.commands {
+		lines = append(lines, usageLines(c, "")...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Role returns the role a user needs to run the command the message is for,
+// which is the Role of the deepest command on its path that has one. It's
+// empty if no role is needed.
+func (r *Router) Role(in message.Basic) (role string) {
+	commands := r.commands
+	for _, tok := range tokenize(in.Text) {
+		var cmd *Command
+		for _, c := range commands {
+			if c.matches(tok.value) {
+				cmd = c
+				break
+			}
+		}
+		if cmd == nil {
+			break
+		}
+		if cmd.Role != "" {
+			role = cmd.Role
+		}
+		commands = cmd.Subcommands
+	}
+	return
+}
+
+// CommandName returns the Name of the top-level command the message is for,
+// whichever of its aliases was used, or "" if it isn't for any of them
+func (r *Router) CommandName(in message.Basic) string {
+	tokens := tokenize(in.Text)
+	if len(tokens) == 0 {
+		return ""
+	}
+	for _, c := range r.commands {
+		if c.matches(tokens[0].value) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// HandleMessage routes the message to its command and returns the reply.
+// If the command sends several replies they're joined into one.
+func (r *Router) HandleMessage(in message.Basic) (out message.Basic) {
+	var replies collectResponder
+	r.HandleMessageStream(in, &replies)
+	out.Text = strings.Join(replies, "\n")
+	return
+}
+
+// HandleMessageStream routes the message to its command and sends its replies
+// through the Responder. If the message doesn't fit the command, the problem
+// and the command's usage are sent instead.
+func (r *Router) HandleMessageStream(in message.Basic, resp Responder) {
+	cmd, args, err := r.route(in.Text)
+	if err != nil {
+		resp.Send(message.Basic{Text: r.errorText(cmd, err)})
+		return
+	}
+	if cmd.StreamHandler != nil {
+		cmd.StreamHandler(in, args, resp)
+		return
+	}
+	resp.Send(cmd.Handler(in, args))
+}
+
+// errorText explains what was wrong with a message and shows the
+// usage of the command it was meant for
+func (r *Router) errorText(cmd *Command, err error) string {
+	argErr, ok := err.(*ArgumentError)
+	if !ok || cmd == nil {
+		return "Sorry, " + err.Error() + "\n" + r.Usage()
+	}
+	parent := strings.TrimSpace(strings.TrimSuffix(argErr.Command, cmd.Name))
+	usage := strings.Join(usageLines(cmd, parent), "\n")
+	if argErr.Reason == "" {
+		return usage
+	}
+	return "Sorry, " + argErr.Reason + "\n" + usage
+}
+
+// route finds the command for the text and parses its arguments.
+// The command is returned along with any error so its usage can be shown.
+func (r *Router) route(text string) (*Command, Args, error) {
+	args := Args{values: map[string]interface{}{}, given: map[string]bool{}}
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil, args, fmt.Errorf("no command given")
+	}
+
+	var cmd *Command
+	for _, c := range r.commands {
+		if c.matches(tokens[0].value) {
+			cmd = c
+			break
+		}
+	}
+	if cmd == nil {
+		return nil, args, fmt.Errorf("`%s` is not a known command", tokens[0].value)
+	}
+	path := cmd.Name
+
+	// Walk down the subcommands
+	i := 1
+	for i < len(tokens) && len(cmd.Subcommands) > 0 {
+		var sub *Command
+		for _, c := range cmd.Subcommands {
+			if c.matches(tokens[i].value) {
+				sub = c
+				break
+			}
+		}
+		if sub == nil {
+			break
+		}
+		cmd = sub
+		path += " " + cmd.Name
+		i++
+	}
+	if !cmd.handles() {
+		if i < len(tokens) {
+			return cmd, args, &ArgumentError{Command: path, Reason: fmt.Sprintf("`%s` is not a subcommand of `%s`", tokens[i].value, path)}
+		}
+		return cmd, args, &ArgumentError{Command: path}
+	}
+
+	// Parse flags and positional arguments
+	positional := 0
+	for i < len(tokens) {
+		tok := tokens[i]
+		if strings.HasPrefix(tok.value, "--") && !tok.quoted {
+			name, value := tok.value[2:], ""
+			hasValue := false
+			if eq := strings.Index(name, "="); eq >= 0 {
+				name, value, hasValue = name[:eq], name[eq+1:], true
+			}
+			flag := cmd.flag(name)
+			if flag == nil {
+				return cmd, args, &ArgumentError{Command: path, Reason: fmt.Sprintf("`--%s` is not a flag of `%s`", name, path)}
+			}
+			if !hasValue && flag.Type != BoolArg {
+				i++
+				if i == len(tokens) {
+					return cmd, args, &ArgumentError{Command: path, Reason: fmt.Sprintf("`--%s` needs a value", flag.Name)}
+				}
+				value, hasValue = tokens[i].value, true
+			}
+			if !hasValue {
+				value = "true"
+			}
+			if err := args.set(*flag, "--"+flag.Name, value); err != nil {
+				return cmd, args, &ArgumentError{Command: path, Reason: err.Error()}
+			}
+			i++
+			continue
+		}
+
+		if positional == len(cmd.Args) {
+			return cmd, args, &ArgumentError{Command: path, Reason: fmt.Sprintf("I wasn't expecting `%s`", tok.value)}
+		}
+		arg := cmd.Args[positional]
+		value := tok.value
+		if arg.Type == TextArg {
+			value = strings.TrimRightFunc(text[tok.start:], unicode.IsSpace)
+			i = len(tokens)
+		} else {
+			i++
+		}
+		if err := args.set(arg, "<"+arg.Name+">", value); err != nil {
+			return cmd, args, &ArgumentError{Command: path, Reason: err.Error()}
+		}
+		positional++
+	}
+
+	// Anything left over must be optional
+	for _, arg := range cmd.Args[positional:] {
+		if !arg.Optional {
+			return cmd, args, &ArgumentError{Command: path, Reason: fmt.Sprintf("`<%s>` is missing", arg.Name)}
+		}
+		args.setDefault(arg)
+	}
+	for _, flag := range cmd.Flags {
+		if !args.given[flag.Name] {
+			args.setDefault(flag)
+		}
+	}
+	return cmd, args, nil
+}
+
+// set parses and validates the value for the argument. label is how the
+// argument is referred to in errors.
+func (a Args) set(arg Arg, label, value string) error {
+	if arg.Pattern != nil && !arg.Pattern.MatchString(value) {
+		return fmt.Errorf("`%s` isn't a valid `%s`", value, label)
+	}
+	switch arg.Type {
+	case IntArg:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("`%s` needs to be a number, not `%s`", label, value)
+		}
+		a.values[arg.Name] = n
+	case BoolArg:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("`%s` needs to be true or false, not `%s`", label, value)
+		}
+		a.values[arg.Name] = b
+	default:
+		a.values[arg.Name] = value
+	}
+	a.given[arg.Name] = true
+	return nil
+}
+
+// setDefault sets the argument to its default value, if it has one
+func (a Args) setDefault(arg Arg) {
+	if arg.Default == "" {
+		return
+	}
+	a.set(arg, arg.Name, arg.Default)
+	a.given[arg.Name] = false
+}
+
+// usageLines describes the command and its subcommands, one line each,
+// with the command's arguments and flags on indented lines below it
+func usageLines(c *Command, prefix string) (lines []string) {
+	path := strings.TrimSpace(prefix + " " + c.Name)
+	if c.handles() {
+		synopsis := []string{path}
+		for _, flag := range c.Flags {
+			if flag.Type == BoolArg {
+				synopsis = append(synopsis, "[--"+flag.Name+"]")
+			} else {
+				synopsis = append(synopsis, "[--"+flag.Name+" <"+flag.Name+">]")
+			}
+		}
+		for _, arg := range c.Args {
+			name := arg.Name
+			if arg.Type == TextArg {
+				name += "..."
+			}
+			if arg.Optional {
+				synopsis = append(synopsis, "["+name+"]")
+			} else {
+				synopsis = append(synopsis, "<"+name+">")
+			}
+		}
+		line := "`" + strings.Join(synopsis, " ") + "`"
+		if c.Description != "" {
+			line += " " + c.Description
+		}
+		lines = append(lines, line)
+
+		for _, flag := range c.Flags {
+			if flag.Description != "" {
+				lines = append(lines, "    `--"+flag.Name+"` "+flag.Description)
+			}
+		}
+		for _, arg := range c.Args {
+			if arg.Description != "" {
+				lines = append(lines, "    `"+arg.Name+"` "+arg.Description)
+			}
+		}
+	}
+	for _, sub := range c.Subcommands {
+		lines = append(lines, usageLines(sub, path)...)
+	}
+	return
+}
+
+// token is a word of a message, with its offset in the message so that
+// TextArg can take the rest of the message as it was typed
+type token struct {
+	value  string
+	start  int
+	quoted bool
+}
+
+// tokenize splits text into words. Double quotes group several
+// words into one.
+func tokenize(text string) (tokens []token) {
+	i := 0
+	for i < len(text) {
+		if r, size := utf8.DecodeRuneInString(text[i:]); unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		start := i
+		if text[i] == '"' {
+			if end := strings.IndexByte(text[i+1:], '"'); end >= 0 {
+				tokens = append(tokens, token{text[i+1 : i+1+end], start, true})
+				i += end + 2
+				continue
+			}
+		}
+		for i < len(text) {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			if unicode.IsSpace(r) {
+				break
+			}
+			i += size
+		}
+		tokens = append(tokens, token{text[start:i], start, false})
+	}
+	return
+}
+
+// collectResponder keeps every reply it's sent
+type collectResponder []string
+
+func (c *collectResponder) Send(out message.Basic) {
+	if out.Text == "" && len(out.Blocks) > 0 {
+		out.Text = message.PlainText(out.Blocks)
+	}
+	if out.Text != "" {
+		*c = append(*c, out.Text)
+	}
+}
@@ -0,0 +1,135 @@
+package plugins
+
+import (
+	"fmt"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+func ExampleRouter() {
+	router := NewRouter(&Command{
+		Name:        "!repeat",
+		Description: "repeats some text",
+		Flags: []Arg{
+			{Name: "times", Type: IntArg, Default: "1", Description: "is how many times to repeat it"},
+			{Name: "shout", Type: BoolArg},
+		},
+		Args: []Arg{{Name: "text", Type: TextArg}},
+		Handler: func(in message.Basic, args Args) (out message.Basic) {
+			for i := 0; i < args.Int("times"); i++ {
+				out.Text += args.String("text") + " "
+			}
+			if args.Bool("shout") {
+				out.Text += "!!!"
+			}
+			return
+		},
+	})
+
+	fmt.Println(router.Regexp().MatchString("!repeat hello"))
+	fmt.Println(router.Regexp().MatchString("!repeated"))
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!repeat --times 2 --shout hello   world"}).Text)
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!repeat --times=lots hello"}).Text)
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!repeat"}).Text)
+	// Output:
+	// true
+	// false
+	// hello   world hello   world !!!
+	// Sorry, `--times` needs to be a number, not `lots`
+	// `!repeat [--times <times>] [--shout] <text...>` repeats some text
+	//     `--times` is how many times to repeat it
+	// Sorry, `<text>` is missing
+	// `!repeat [--times <times>] [--shout] <text...>` repeats some text
+	//     `--times` is how many times to repeat it
+}
+
+func ExampleRouter_subcommands() {
+	router := NewRouter(&Command{
+		Name:    "!deploy",
+		Aliases: []string{"!ship"},
+		Subcommands: []*Command{
+			{
+				Name:        "start",
+				Description: "deploys an app",
+				Args: []Arg{
+					{Name: "app"},
+					{Name: "env", Optional: true, Default: "staging"},
+				},
+				Handler: func(in message.Basic, args Args) message.Basic {
+					return message.Basic{Text: "deploying " + args.String("app") + " to " + args.String("env")}
+				},
+			},
+			{
+				Name:        "status",
+				Description: "shows what's deploying",
+				Handler: func(in message.Basic, args Args) message.Basic {
+					return message.Basic{Text: "nothing is deploying"}
+				},
+			},
+		},
+	})
+
+	fmt.Println(router.Command())
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!ship START \"my app\""}).Text)
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!deploy start api production"}).Text)
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!deploy status now"}).Text)
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!deploy"}).Text)
+	// Output:
+	// [!deploy]
+	// deploying my app to staging
+	// deploying api to production
+	// Sorry, I wasn't expecting `now`
+	// `!deploy status` shows what's deploying
+	// `!deploy start <app> [env]` deploys an app
+	// `!deploy status` shows what's deploying
+}
+
+func ExampleRouter_CommandName() {
+	router := NewRouter(&Command{
+		Name:    "!remind",
+		Aliases: []string{"!reminder"},
+		Handler: func(in message.Basic, args Args) (out message.Basic) { return },
+	})
+	fmt.Printf("%q\n", router.CommandName(message.Basic{Text: "!Reminder me at 4pm to leave"}))
+	fmt.Printf("%q\n", router.CommandName(message.Basic{Text: "!dice 2d6"}))
+	// Output:
+	// "!remind"
+	// ""
+}
+
+func ExampleRouter_Role() {
+	router := NewRouter(&Command{
+		Name: "!deploy",
+		Role: "deployer",
+		Subcommands: []*Command{
+			{Name: "start", Handler: func(in message.Basic, args Args) (out message.Basic) { return }},
+			{Name: "status", Handler: func(in message.Basic, args Args) (out message.Basic) { return }},
+			{Name: "rollback", Role: "admin", Handler: func(in message.Basic, args Args) (out message.Basic) { return }},
+		},
+	})
+
+	fmt.Printf("%q\n", router.Role(message.Basic{Text: "!deploy start api"}))
+	fmt.Printf("%q\n", router.Role(message.Basic{Text: "!deploy rollback api"}))
+	fmt.Printf("%q\n", router.Role(message.Basic{Text: "!dice 2d6"}))
+	// Output:
+	// "deployer"
+	// "admin"
+	// ""
+}
+
+func ExampleRouter_unicode() {
+	router := NewRouter(&Command{
+		Name: "!say",
+		Args: []Arg{{Name: "word"}, {Name: "rest", Type: TextArg}},
+		Handler: func(in message.Basic, args Args) (out message.Basic) {
+			out.Text = fmt.Sprintf("%q %q", args.String("word"), args.String("rest"))
+			return
+		},
+	})
+
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!say voilà à\u00a0bientôt"}).Text)
+	fmt.Println(router.HandleMessage(message.Basic{Text: "!say\u3000日本語 テキスト"}).Text)
+	// Output:
+	// "voilà" "à\u00a0bientôt"
+	// "日本語" "テキスト"
+}
@@ -0,0 +1,36 @@
+package plugins
+
+import (
+	"time"
+
+	"github.com/handwritingio/deckard-bot/message"
+)
+
+// ReplyHandler handles the next message in a conversation
+type ReplyHandler func(next message.Basic, r Responder)
+
+// Conversations lets a plugin ask a follow-up question and get the answer,
+// e.g. "which repo?" or "are you sure? yes/no"
+type Conversations interface {
+	// Await claims the next message sent by in's sender, in the same channel
+	// and on the same connection. It's handed to handle instead of being
+	// matched against the plugins' regexps, even if it doesn't look like a
+	// command. The claim lasts until that message arrives, the timeout passes
+	// or cancel is called. To keep the conversation going, call Await again
+	// from handle. A newer claim on the same sender and channel replaces
+	// an older one.
+	Await(in message.Basic, timeout time.Duration, handle ReplyHandler) (cancel func())
+
+	// AwaitOrExpire is Await, but calls expired if the timeout passes before
+	// the next message arrives, e.g. to forget what the plugin asked about
+	AwaitOrExpire(in message.Basic, timeout time.Duration, handle ReplyHandler, expired func()) (cancel func())
+}
+
+// ConversationPlugin is a Plugin that has conversations. SetConversations
+// is called before every OnInit.
+type ConversationPlugin interface {
+	Plugin
+
+	// SetConversations gives the plugin its conversations
+	SetConversations(Conversations)
+}
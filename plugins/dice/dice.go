@@ -9,46 +9,65 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
 )
 
 // Plugin ...
 type Plugin struct{}
 
+func init() {
+	plugins.Register("dice", func(settings config.Section) (plugins.Plugin, error) {
+		p := &Plugin{}
+		return p, settings.Decode(p)
+	})
+}
+
 var (
-	// Dice Regexp variables for logic in HandleMessage
-	reDice = regexp.MustCompile(`(?i)^!dice`)
-	reRoll = regexp.MustCompile(`(?i)^!dice (\d{1,5})d(\d{1,5})$`)
+	// reRoll matches the nDm argument, e.g. 2d6
+	reRoll = regexp.MustCompile(`(?i)^(\d{1,5})d(\d{1,5})$`)
 	// Seeded random number generator
 	rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	commands = plugins.NewRouter(&plugins.Command{
+		Name:        "!dice",
+		Description: "rolls some dice",
+		Args: []plugins.Arg{{
+			Name:        "nDm",
+			Description: "where n is the number of dice and m is the number of sides (e.g. `!dice 2d6` means roll 2 6-sided dice)",
+			Pattern:     reRoll,
+		}},
+		Handler: handleRoll,
+	})
 )
 
 // Regexp returns the regexp of a message that should be handled by this plugin
 func (p Plugin) Regexp() *regexp.Regexp {
-	return reDice
+	return commands.Regexp()
 }
 
 // Command returns a list of commands the plugin provides
 func (p Plugin) Command() []string {
-	return []string{"!dice"}
+	return commands.Command()
 }
 
 // Usage prints detailed usage instructions for the plugin
 func (p Plugin) Usage() string {
-	return "`!dice nDm` where n is the number of dice and m is the number of sides " +
-		"(e.g. `!dice 2d6` means roll 2 6-sided dice)"
+	return commands.Usage()
 }
 
 // HandleMessage is responsible for handling the incoming message
 // and returning a response based on the message provides
 func (p Plugin) HandleMessage(in message.Basic) (out message.Basic) {
 	log.Debug("dice matched...")
-	chunks := reRoll.FindStringSubmatch(in.Text)
-	if len(chunks) != 3 { // invalid command
-		out.Text = p.Usage()
-		return
-	}
+	return commands.HandleMessage(in)
+}
+
+// handleRoll rolls the dice from a `!dice nDm` command
+func handleRoll(in message.Basic, args plugins.Args) (out message.Basic) {
+	chunks := reRoll.FindStringSubmatch(args.String("nDm"))
 
 	// We don't need to worry about the strings not being ints because the regex only
 	// matches ints, and not negative ints or other chars
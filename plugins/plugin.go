@@ -48,12 +48,74 @@ Here's an example of an implementation of the Plugin interface
  	out.Text = "Sample Plugin Output"
  	return
  }
+
+A plugin that needs to reply more than once, or not at all, can also implement
+the StreamingPlugin interface. The bot will then call HandleMessageStream
+instead of HandleMessage and the plugin sends each reply through the Responder
+
+ // HandleMessageStream lets the user know we're busy before replying
+ func (p *Plugin) HandleMessageStream(in message.Basic, r plugins.Responder) {
+ 	r.Send(message.Basic{Text: "Working on it..."})
+ 	time.Sleep(5 * time.Second)
+ 	r.Send(p.HandleMessage(in))
+ }
+
+Commands
+
+Rather than writing regular expressions and usage strings by hand, a plugin can
+declare its commands and their arguments with a Router. The Router builds the
+plugin's Regexp, Command list and Usage from the declaration, parses the
+arguments and calls the command's handler, replying with the problem and the
+usage if a message doesn't fit
+
+ var commands = plugins.NewRouter(&plugins.Command{
+ 	Name:        "!dice",
+ 	Description: "rolls some dice",
+ 	Args:        []plugins.Arg{{Name: "sides", Type: plugins.IntArg}},
+ 	Handler: func(in message.Basic, args plugins.Args) message.Basic {
+ 		return message.Basic{Text: strconv.Itoa(rand.Intn(args.Int("sides")) + 1)}
+ 	},
+ })
+
+ func (p *Plugin) Usage() string                                 { return commands.Usage() }
+ func (p *Plugin) Command() []string                             { return commands.Command() }
+ func (p *Plugin) Regexp() *regexp.Regexp                        { return commands.Regexp() }
+ func (p *Plugin) HandleMessage(in message.Basic) message.Basic { return commands.HandleMessage(in) }
+
+A plugin whose commands have aliases also implements CommandPlugin, so that an
+alias counts against the same rate limit as the command
+
+ func (p *Plugin) CommandName(in message.Basic) string { return commands.CommandName(in) }
+
+Access
+
+A command that not everyone should run declares the Role it needs, and the
+plugin implements RestrictedPlugin. The bot checks the sender has the role
+before the plugin is sent the message, and apologises to them if not
+
+ {Name: "deploy", Role: "deployer", Handler: handleDeploy}
+
+ func (p *Plugin) Role(in message.Basic) string { return commands.Role(in) }
+
+Registry
+
+A plugin package registers a Factory under the plugin's name when it's
+imported, and the bot creates the plugins enabled in its configuration file
+with New, passing each its section of the file
+
+ func init() {
+ 	plugins.Register("sample", func(settings config.Section) (plugins.Plugin, error) {
+ 		p := &Plugin{}
+ 		return p, settings.Decode(p)
+ 	})
+ }
 */
 package plugins
 
 import (
 	"regexp"
 
+	"github.com/handwritingio/deckard-bot/brain"
 	"github.com/handwritingio/deckard-bot/message"
 )
 
@@ -91,3 +153,66 @@ type Plugin interface {
 	// be as generic as possible for what the plugin requires.
 	Regexp() *regexp.Regexp
 }
+
+// StreamingPlugin is a Plugin that can send any number of replies to a
+// message. If a plugin implements it, the bot calls HandleMessageStream
+// instead of HandleMessage.
+type StreamingPlugin interface {
+	Plugin
+
+	// HandleMessageStream handles the message and sends zero or more replies
+	// through the Responder, e.g. a "working on it" message followed by the
+	// result, or a long answer split into several messages. The bot considers
+	// the plugin finished with the message once this method returns.
+	HandleMessageStream(message.Basic, Responder)
+}
+
+// ShutdownPlugin is a Plugin that needs to clean up after itself. OnShutdown
+// is called when the plugin is disabled or reloaded, and when the bot stops.
+// The plugin won't be sent any more messages once OnShutdown is called, and
+// OnInit will be called again before it is.
+type ShutdownPlugin interface {
+	Plugin
+
+	// OnShutdown releases anything the plugin acquired in OnInit
+	OnShutdown() error
+}
+
+// BrainPlugin is a Plugin that keeps state in the bot's brain. SetBrain is
+// called with the plugin's own namespace of the brain before every OnInit.
+type BrainPlugin interface {
+	Plugin
+
+	// SetBrain gives the plugin the store to keep its state in. Keys are
+	// private to the plugin and survive restarts if the bot's brain is on disk.
+	SetBrain(brain.Store)
+}
+
+// RestrictedPlugin is a Plugin whose commands can't be run by everyone.
+// The bot only sends it a message if the sender has the role it needs.
+type RestrictedPlugin interface {
+	Plugin
+
+	// Role returns the role a user needs for the plugin to handle the
+	// message, or "" if anyone may send it
+	Role(message.Basic) string
+}
+
+// CommandPlugin is a Plugin that can tell which of its commands a message
+// calls, so that the command's aliases share its rate limit
+type CommandPlugin interface {
+	Plugin
+
+	// CommandName returns the command the message calls, as listed by
+	// Command, or "" if it isn't one of them
+	CommandName(message.Basic) string
+}
+
+// Responder sends replies to the message a StreamingPlugin is handling
+type Responder interface {
+	// Send replies to the message. The reply's ID and Finished fields are
+	// set by the bot and blank replies are ignored. Send is safe to call from
+	// other goroutines, but replies sent after HandleMessageStream has returned
+	// or timed out are discarded.
+	Send(message.Basic)
+}
@@ -8,10 +8,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/github"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
 
 	"github.com/renstrom/fuzzysearch/fuzzy"
 )
@@ -19,6 +22,16 @@ import (
 // Plugin holds the list of principles
 type Plugin struct {
 	List []*Principle
+
+	routerOnce sync.Once
+	router     *plugins.Router
+}
+
+func init() {
+	plugins.Register("principles", func(settings config.Section) (plugins.Plugin, error) {
+		p := &Plugin{}
+		return p, settings.Decode(p)
+	})
 }
 
 // Principle contains the title and description of an engineering principle
@@ -29,12 +42,8 @@ type Principle struct {
 }
 
 var (
-	// rePrinciple is the regexp variables for logic in HandleMessage
-	rePrinciple        = regexp.MustCompile(`(?i)^!principle`)
-	rePrincipleAll     = regexp.MustCompile(`(?i)^!principles?$`)
-	rePrincipleNum     = regexp.MustCompile(`(?i)^!principles?\s+(\d+)`)
-	rePrincipleKeyword = regexp.MustCompile(`(?i)^!principles?\s+(.+)`)
-	rePrincipleFormat  = regexp.MustCompile(`(?i)^(\d+\.)\s+\*\*(\w.+)\*\*\s+(.+)`)
+	// rePrincipleFormat matches a principle in the principles document
+	rePrincipleFormat = regexp.MustCompile(`(?i)^(\d+\.)\s+\*\*(\w.+)\*\*\s+(.+)`)
 
 	// Default values for weighting the fuzzy search to prefer the title
 	// The higher the value, the less important it will be in returning a match
@@ -46,19 +55,46 @@ var (
 	principleOrg      = "handwritingio"
 	principleRepo     = "principles"
 	principleFilename = "EngineeringPrinciples.md"
+
+	// principlesPerMessage is how many principles are sent in each message
+	// when listing all of them, to keep each message a readable size
+	principlesPerMessage = 4
 )
 
+// commands returns the plugin's command router, building it the first
+// time it's needed since the handlers need the plugin's list
+func (p *Plugin) commands() *plugins.Router {
+	p.routerOnce.Do(func() {
+		p.router = plugins.NewRouter(&plugins.Command{
+			Name:        "!principle",
+			Aliases:     []string{"!principles"},
+			Description: "will list all the Engineering principles",
+			Args: []plugins.Arg{{
+				Name:        "number or keyword",
+				Type:        plugins.TextArg,
+				Optional:    true,
+				Description: "will list the specified principle, or search for one based on the keyword",
+			}},
+			StreamHandler: p.handlePrinciple,
+		})
+	})
+	return p.router
+}
+
 // Usage returns the Plugin's usage
 func (p *Plugin) Usage() string {
-	return "`!principle` will give you some Engineering principles\n" +
-		"`!principles` will list all\n" +
-		"`!principle {digit} will list the specified principle" +
-		"`!principle {keyword} will search for a principle based on the keyword"
+	return p.commands().Usage()
 }
 
 // Command lists the base commands to use the plugin
 func (p *Plugin) Command() []string {
-	return []string{"!principle"}
+	return p.commands().Command()
+}
+
+// CommandName returns the command the message calls, so its aliases share
+// its rate limit
+func (p *Plugin) CommandName(in message.Basic) string {
+	return p.commands().CommandName(in)
 }
 
 // OnInit handles all actions that should occur when the plugin starts
@@ -84,63 +120,68 @@ func (p *Plugin) Name() string {
 }
 
 // Regexp returns the regexp of a message that should be handled by this plugin
-func (p Plugin) Regexp() *regexp.Regexp {
-	return rePrinciple
+func (p *Plugin) Regexp() *regexp.Regexp {
+	return p.commands().Regexp()
+}
+
+// HandleMessage takes a message.Basic in and returns a message.Basic with a response
+func (p *Plugin) HandleMessage(in message.Basic) message.Basic {
+	return p.commands().HandleMessage(in)
 }
 
-// HandleMessage takes a message.Basic in, checks to see if it needs to do anything
-// with it (using regexp), and returns a message.Basic with a response
-func (p *Plugin) HandleMessage(in message.Basic) (out message.Basic) {
+// HandleMessageStream sends the full list of principles a few at a time,
+// rather than as one wall of text
+func (p *Plugin) HandleMessageStream(in message.Basic, r plugins.Responder) {
+	p.commands().HandleMessageStream(in, r)
+}
 
+// handlePrinciple lists all the principles when no argument is given, the
+// numbered principle when given a number (`!principle 5`) or searches for a
+// principle based on a keyword (`!principle code`)
+func (p *Plugin) handlePrinciple(in message.Basic, args plugins.Args, r plugins.Responder) {
 	if len(p.List) == 0 {
-		out.Text = "Sorry, there are no principles loaded at this time."
+		r.Send(message.Basic{Text: "Sorry, there are no principles loaded at this time."})
 		return
 	}
-	switch {
-	// Matches the command to list all (`!principle` or `!principles`)
-	case rePrincipleAll.MatchString(in.Text):
-
-		var s []string
-		for i, principle := range p.List {
-			num := i + 1
-			s = append(s, fmt.Sprintf("%d. *%s*: %s", num, principle.Title, principle.Description))
-		}
-		out.Text = strings.Join(s, "\n")
 
-	// Matches the command to list a numbered principle (`!principle 5`)
-	case rePrincipleNum.MatchString(in.Text):
-		chunks := rePrincipleNum.FindStringSubmatch(in.Text)
-		if len(chunks) != 2 { // invalid command
-			out.Text = p.Usage()
-			return
+	query := args.String("number or keyword")
+	if query == "" {
+		all := p.listAll()
+		for start := 0; start < len(all); start += principlesPerMessage {
+			end := start + principlesPerMessage
+			if end > len(all) {
+				end = len(all)
+			}
+			r.Send(message.Basic{Text: strings.Join(all[start:end], "\n")})
 		}
-		num, _ := strconv.Atoi(chunks[1])
-		if num >= len(p.List) {
-			out.Text = "Sorry, the principle you requested does not exist"
+		return
+	}
+
+	if num, err := strconv.Atoi(query); err == nil {
+		if num < 1 || num > len(p.List) {
+			r.Send(message.Basic{Text: "Sorry, the principle you requested does not exist"})
 			return
 		}
 		principle := p.List[num-1]
-		out.Text = fmt.Sprintf("%d. *%s*: %s", principle.Number, principle.Title, principle.Description)
+		r.Send(message.Basic{Text: fmt.Sprintf("%d. *%s*: %s", principle.Number, principle.Title, principle.Description)})
+		return
+	}
 
-	// Matches the command to list a principle based on a keyword (`!principle code`)
-	case rePrincipleKeyword.MatchString(in.Text):
-		chunks := rePrincipleKeyword.FindStringSubmatch(in.Text)
-		if len(chunks) != 2 { // invalid command
-			out.Text = p.Usage()
-			return
-		}
-		keyword := chunks[1]
-		matchPrinciple := p.fuzzySearch(keyword)
-		if matchPrinciple == nil {
-			out.Text = fmt.Sprintf("Sorry, no principles match keyword `%s`", keyword)
-			return
-		}
-		out.Text = fmt.Sprintf("%d. *%s*: %s", matchPrinciple.Number, matchPrinciple.Title, matchPrinciple.Description)
-	default:
-		out.Text = p.Usage()
+	matchPrinciple := p.fuzzySearch(query)
+	if matchPrinciple == nil {
+		r.Send(message.Basic{Text: fmt.Sprintf("Sorry, no principles match keyword `%s`", query)})
+		return
 	}
+	r.Send(message.Basic{Text: fmt.Sprintf("%d. *%s*: %s", matchPrinciple.Number, matchPrinciple.Title, matchPrinciple.Description)})
+}
 
-	return out
+// listAll returns every principle formatted for output, in order
+func (p *Plugin) listAll() (s []string) {
+	for i, principle := range p.List {
+		num := i + 1
+		s = append(s, fmt.Sprintf("%d. *%s*: %s", num, principle.Title, principle.Description))
+	}
+	return
 }
 
 // fuzzySearch takes a keyword as a string, completes a search through titles and descriptions
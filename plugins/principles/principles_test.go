@@ -11,21 +11,23 @@ func ExamplePlugin_HandleMessage() {
 	principleList := buildPrinciples(principleData)
 	p.List = principleList
 
-	fmt.Println(rePrinciple.MatchString("!principles"))
-	fmt.Println(rePrinciple.MatchString("!principle"))
-	fmt.Println(rePrincipleNum.MatchString("!principle 99"))
-	fmt.Println(rePrincipleKeyword.FindStringSubmatch("!principle clever code")[1])
+	fmt.Println(p.Regexp().MatchString("!principles"))
+	fmt.Println(p.Regexp().MatchString("!principle"))
+	fmt.Println(p.Regexp().MatchString("!principle 99"))
+	fmt.Println(p.Regexp().MatchString("!principled"))
 	fmt.Println(p.HandleMessage(format("!principle 1")).Text)
 	fmt.Println(p.HandleMessage(format("!principles 12")).Text)
+	fmt.Println(p.HandleMessage(format("!principle 0")).Text)
 	fmt.Println(p.HandleMessage(format("!principle clever code")).Text)
 	fmt.Println(p.HandleMessage(format("!principles mary had a little lamb")).Text)
 	// Output:
 	// true
 	// true
 	// true
-	// clever code
+	// false
 	// 1. *Build what matters*: Engineering effort is a scarce commodity. It should only be applied to problems that "move the needle" for the company.
 	// Sorry, the principle you requested does not exist
+	// Sorry, the principle you requested does not exist
 	// 6. *Clear Code Beats Clever Code*: Don't write code you can't debug at 3AM while drunk. Never name a variable 'data' or 'info'. If the implementation is hard to explain, it's a bad idea.
 	// Sorry, no principles match keyword `mary had a little lamb`
 }
@@ -90,3 +92,35 @@ done, to allow for more meaningful collaboration.
 lie on the surface. They’re buried deep beneath layers of assumptions,
 misconceptions, and politics. To think like a user, work with a
 user.`)
+
+// printResponder prints each reply it's sent
+type printResponder struct{}
+
+func (printResponder) Send(out message.Basic) {
+	fmt.Println("--")
+	fmt.Println(out.Text)
+}
+
+func ExamplePlugin_Usage() {
+	p := new(Plugin)
+	fmt.Println(p.Usage())
+	// Output:
+	// `!principle [number or keyword...]` will list all the Engineering principles
+	//     `number or keyword` will list the specified principle, or search for one based on the keyword
+}
+
+func ExamplePlugin_HandleMessageStream() {
+	p := new(Plugin)
+	p.List = buildPrinciples(principleData)[:6]
+
+	p.HandleMessageStream(format("!principles"), printResponder{})
+	// Output:
+	// --
+	// 1. *Build what matters*: Engineering effort is a scarce commodity. It should only be applied to problems that "move the needle" for the company.
+	// 2. *Be a scientist*: Science is a systematic enterprise that builds and organizes knowledge in the form of testable explanations and predictions about the universe. We should base our decisions on objective data obtained through research rather than hunches or superstition. To improve is to change.
+	// 3. *Know your enemies*: Conway's Law, sleep deprivation, subjective beliefs, lax validation, crappy dependencies, unreliable networks, etc... Know them and have a plan to beat them.
+	// 4. *Don’t live with broken windows*: Fix bad designs, wrong decisions, and poor code when you see them.
+	// --
+	// 5. *Don’t repeat yourself*: Every piece of knowledge/process must have a single, unambiguous, authoritative representation within a system.
+	// 6. *Clear Code Beats Clever Code*: Don't write code you can't debug at 3AM while drunk. Never name a variable 'data' or 'info'. If the implementation is hard to explain, it's a bad idea.
+}
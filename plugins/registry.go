@@ -0,0 +1,68 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/handwritingio/deckard-bot/config"
+)
+
+// Factory creates a plugin from the settings in its section of the
+// configuration file. It should return an error if the settings are wrong,
+// e.g. by decoding them with settings.Decode.
+type Factory func(settings config.Section) (Plugin, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a plugin available by name, so it can be enabled in the
+// configuration file. Plugin packages call it from init, so importing the
+// package is enough to make the plugin available:
+//
+//	func init() {
+//		plugins.Register("dice", func(settings config.Section) (plugins.Plugin, error) {
+//			p := &Plugin{}
+//			return p, settings.Decode(p)
+//		})
+//	}
+//
+// Names are lowercase, and Register panics if the name is taken.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	name = strings.ToLower(name)
+	if factory == nil {
+		panic("plugins: Register factory for " + name + " is nil")
+	}
+	if _, taken := factories[name]; taken {
+		panic("plugins: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Registered returns the names of every registered plugin, sorted
+func Registered() (names []string) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// New creates the registered plugin with the name, ignoring case, from its
+// settings
+func New(name string, settings config.Section) (Plugin, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[strings.ToLower(name)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("there's no plugin named %s, try one of %s", name, strings.Join(Registered(), ", "))
+	}
+	return factory(settings)
+}
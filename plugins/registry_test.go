@@ -0,0 +1,41 @@
+package plugins_test
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+type greeter struct {
+	Greeting string `yaml:"greeting"`
+}
+
+func (g *greeter) Name() string                                       { return "Greeter" }
+func (g *greeter) Usage() string                                      { return "" }
+func (g *greeter) Command() []string                                  { return nil }
+func (g *greeter) OnInit() error                                      { return nil }
+func (g *greeter) Regexp() *regexp.Regexp                             { return regexp.MustCompile(`^!greet`) }
+func (g *greeter) HandleMessage(in message.Basic) (out message.Basic) { return }
+
+func ExampleRegister() {
+	plugins.Register("greeter", func(settings config.Section) (plugins.Plugin, error) {
+		g := &greeter{Greeting: "Hello"}
+		return g, settings.Decode(g)
+	})
+
+	p, err := plugins.New("Greeter", config.Section{"greeting": "Howdy"})
+	fmt.Println(p.(*greeter).Greeting, err)
+
+	_, err = plugins.New("greeter", config.Section{"greting": "Howdy"})
+	fmt.Println(err != nil)
+
+	_, err = plugins.New("farewell", nil)
+	fmt.Println(err)
+	// Output:
+	// Howdy <nil>
+	// true
+	// there's no plugin named farewell, try one of greeter
+}
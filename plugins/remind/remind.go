@@ -0,0 +1,353 @@
+// Package remind is a plugin that reminds people to do things later, once
+// or on a schedule (`!remind me in 10m to stretch`). Reminders are kept in
+// the bot's brain and scheduler, so they survive restarts.
+package remind
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// Plugin keeps track of everyone's reminders
+type Plugin struct {
+	// Now returns the current time, and can be replaced in tests
+	Now func() time.Time
+
+	mu        sync.Mutex
+	scheduler plugins.Scheduler
+	brain     brain.Store
+
+	routerOnce sync.Once
+	router     *plugins.Router
+}
+
+func init() {
+	plugins.Register("remind", func(settings config.Section) (plugins.Plugin, error) {
+		p := &Plugin{}
+		return p, settings.Decode(p)
+	})
+}
+
+// reminder is what's kept in the brain for each reminder
+type reminder struct {
+	ID   string
+	User message.User
+	Text string
+	When string
+
+	// Private reminders are sent without a mention, since they go to a
+	// direct message
+	Private bool
+}
+
+// timeFormat is how the next time a reminder goes off is shown, in the time
+// zone it's worked out in
+const timeFormat = "Mon Jan 2 at 3:04pm MST"
+
+// commands returns the plugin's command router, building it the first
+// time it's needed since the handlers need the plugin
+func (p *Plugin) commands() *plugins.Router {
+	p.routerOnce.Do(func() {
+		p.router = plugins.NewRouter(&plugins.Command{
+			Name:    "!remind",
+			Aliases: []string{"!reminder", "!reminders"},
+			Subcommands: []*plugins.Command{
+				{
+					Name:        "me",
+					Description: "reminds you, e.g. `!remind me tomorrow at 9 to call Sam` or `!remind me every friday at 4pm to do timesheets`",
+					Args: []plugins.Arg{{
+						Name:        "when to what",
+						Type:        plugins.TextArg,
+						Description: "when can be `in 2h`, `at 4pm`, `tomorrow at 9`, `on friday`, `every 30m`, `every day at 9am`, `every weekday at 9:30` or `every monday`",
+					}},
+					Flags: []plugins.Arg{{
+						Name:        "dm",
+						Type:        plugins.BoolArg,
+						Description: "sends the reminder to you as a direct message instead of in this channel",
+					}},
+					Handler: p.handleRemind,
+				},
+				{
+					Name:        "list",
+					Description: "lists your reminders",
+					Handler:     p.handleList,
+				},
+				{
+					Name:        "cancel",
+					Description: "cancels one of your reminders",
+					Args:        []plugins.Arg{{Name: "id", Description: "the reminder's number from `!remind list`"}},
+					Handler:     p.handleCancel,
+				},
+			},
+		})
+	})
+	return p.router
+}
+
+// Name returns the name of the plugin
+func (p *Plugin) Name() string {
+	return "Reminders"
+}
+
+// Usage returns the Plugin's usage
+func (p *Plugin) Usage() string {
+	return p.commands().Usage()
+}
+
+// Command lists the base commands to use the plugin
+func (p *Plugin) Command() []string {
+	return p.commands().Command()
+}
+
+// Regexp returns the regexp of a message that should be handled by this plugin
+func (p *Plugin) Regexp() *regexp.Regexp {
+	return p.commands().Regexp()
+}
+
+// HandleMessage takes a message.Basic in and returns a message.Basic with a response
+func (p *Plugin) HandleMessage(in message.Basic) message.Basic {
+	return p.commands().HandleMessage(in)
+}
+
+// CommandName returns the command the message calls, so its aliases share
+// its rate limit
+func (p *Plugin) CommandName(in message.Basic) string {
+	return p.commands().CommandName(in)
+}
+
+// SetBrain gives the plugin the store it keeps reminders in
+func (p *Plugin) SetBrain(b brain.Store) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.brain = b
+}
+
+// SetScheduler gives the plugin the scheduler that sends reminders
+func (p *Plugin) SetScheduler(s plugins.Scheduler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scheduler = s
+}
+
+// OnInit checks the bot gave the plugin a brain and scheduler
+func (p *Plugin) OnInit() error {
+	if _, _, err := p.stores(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// stores returns the plugin's brain and scheduler
+func (p *Plugin) stores() (brain.Store, plugins.Scheduler, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.brain == nil || p.scheduler == nil {
+		return nil, nil, errors.New("reminders need the bot's brain and scheduler")
+	}
+	return p.brain, p.scheduler, nil
+}
+
+func (p *Plugin) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// location is the time zone reminders are set in for the user: their own if
+// the connection knows it, and the bot's otherwise
+func (p *Plugin) location(user message.User) *time.Location {
+	if user.TimeZone != "" {
+		if loc, err := time.LoadLocation(user.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return p.now().Location()
+}
+
+// HandleJob sends a reminder when it goes off
+func (p *Plugin) HandleJob(job plugins.Job, r plugins.Responder) {
+	store, _, err := p.stores()
+	if err != nil {
+		r.Send(message.Basic{Text: "Reminder: " + job.Text})
+		return
+	}
+	rem, err := loadReminder(store, job.ID)
+	if err != nil {
+		r.Send(message.Basic{Text: "Reminder: " + job.Text})
+		return
+	}
+
+	text := "Reminder: " + rem.Text
+	if !rem.Private {
+		text = mention(rem.User) + " " + text
+	}
+	r.Send(message.Basic{Text: text})
+
+	if !job.At.IsZero() {
+		// it won't go off again
+		store.Delete(reminderKey(job.ID))
+	}
+}
+
+// handleRemind sets up a reminder from `!remind me <when> to <what>`
+func (p *Plugin) handleRemind(in message.Basic, args plugins.Args) (out message.Basic) {
+	store, scheduler, err := p.stores()
+	if err != nil {
+		out.Text = "Sorry, " + err.Error()
+		return
+	}
+
+	parts := strings.SplitN(args.String("when to what"), " to ", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		out.Text = "Sorry, tell me when and what to remind you about, e.g. `!remind me in 10m to stretch`"
+		return
+	}
+	when, what := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	loc := p.location(in.Sender)
+	s, err := parseWhen(when, p.now().In(loc))
+	if err != nil {
+		out.Text = "Sorry, " + err.Error()
+		return
+	}
+
+	id, err := nextID(store)
+	if err != nil {
+		out.Text = "Sorry, I couldn't save your reminder: " + err.Error()
+		return
+	}
+	rem := reminder{
+		ID:      id,
+		User:    in.Sender,
+		Text:    what,
+		When:    when,
+		Private: in.Direct || args.Bool("dm"),
+	}
+	job := plugins.Job{
+		ID:         id,
+		Connection: in.Connection,
+		Channel:    in.Channel,
+		Text:       what,
+		At:         s.At,
+		Every:      s.Every,
+		Cron:       s.Cron,
+	}
+	if s.Cron != "" && loc != time.Local {
+		job.TimeZone = loc.String()
+	}
+	if args.Bool("dm") {
+		job.Channel = in.Sender.ID
+	}
+
+	if err := saveReminder(store, rem); err != nil {
+		out.Text = "Sorry, I couldn't save your reminder: " + err.Error()
+		return
+	}
+	job, err = scheduler.Schedule(job)
+	if err != nil {
+		store.Delete(reminderKey(id))
+		out.Text = "Sorry, " + err.Error()
+		return
+	}
+	out.Text = fmt.Sprintf("OK, I'll remind you %s to %s. The first reminder is %s.", when, what, job.Next.In(loc).Format(timeFormat))
+	return
+}
+
+// handleList lists the sender's reminders
+func (p *Plugin) handleList(in message.Basic, args plugins.Args) (out message.Basic) {
+	store, scheduler, err := p.stores()
+	if err != nil {
+		out.Text = "Sorry, " + err.Error()
+		return
+	}
+
+	loc := p.location(in.Sender)
+	s := []string{"*Your reminders:*"}
+	for _, job := range scheduler.Jobs() {
+		rem, err := loadReminder(store, job.ID)
+		if err != nil || rem.User.ID != in.Sender.ID {
+			continue
+		}
+		s = append(s, fmt.Sprintf("• `%s` %s to %s, next %s", rem.ID, rem.When, rem.Text, job.Next.In(loc).Format(timeFormat)))
+	}
+	if len(s) == 1 {
+		out.Text = "You don't have any reminders"
+		return
+	}
+	out.Text = strings.Join(s, "\n")
+	return
+}
+
+// handleCancel cancels one of the sender's reminders
+func (p *Plugin) handleCancel(in message.Basic, args plugins.Args) (out message.Basic) {
+	store, scheduler, err := p.stores()
+	if err != nil {
+		out.Text = "Sorry, " + err.Error()
+		return
+	}
+
+	id := args.String("id")
+	rem, err := loadReminder(store, id)
+	if err != nil || rem.User.ID != in.Sender.ID {
+		out.Text = fmt.Sprintf("Sorry, you don't have a reminder `%s`", id)
+		return
+	}
+	if err := scheduler.Cancel(id); err != nil {
+		out.Text = "Sorry, " + err.Error()
+		return
+	}
+	store.Delete(reminderKey(id))
+	out.Text = fmt.Sprintf("OK, I won't remind you to %s", rem.Text)
+	return
+}
+
+// mention returns how to mention the user in a reminder
+func mention(user message.User) string {
+	if user.Mention != "" {
+		return user.Mention
+	}
+	return "@" + user.Name
+}
+
+func reminderKey(id string) string {
+	return "reminders/" + id
+}
+
+// nextID returns the next reminder number, so reminders are easy to cancel
+func nextID(store brain.Store) (id string, err error) {
+	err = store.Update("next-id", 0, func(old []byte) ([]byte, error) {
+		n, _ := strconv.Atoi(string(old))
+		n++
+		id = strconv.Itoa(n)
+		return []byte(id), nil
+	})
+	return
+}
+
+func loadReminder(store brain.Store, id string) (rem reminder, err error) {
+	data, err := store.Get(reminderKey(id))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &rem)
+	return
+}
+
+func saveReminder(store brain.Store, rem reminder) error {
+	data, err := json.Marshal(rem)
+	if err != nil {
+		return err
+	}
+	return store.Set(reminderKey(rem.ID), data, 0)
+}
@@ -0,0 +1,81 @@
+package remind
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/handwritingio/deckard-bot/brain"
+	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
+)
+
+// fakeScheduler keeps jobs without running them
+type fakeScheduler struct {
+	jobs []plugins.Job
+}
+
+func (s *fakeScheduler) Schedule(job plugins.Job) (plugins.Job, error) {
+	job.Next = job.At
+	s.jobs = append(s.jobs, job)
+	return job, nil
+}
+
+func (s *fakeScheduler) Cancel(id string) error {
+	for i, job := range s.jobs {
+		if job.ID == id {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("there's no job %s", id)
+}
+
+func (s *fakeScheduler) Jobs() []plugins.Job {
+	return s.jobs
+}
+
+// printResponder prints replies with the channel they're for
+type printResponder struct {
+	channel string
+}
+
+func (r printResponder) Send(out message.Basic) {
+	fmt.Println(r.channel, out.Text)
+}
+
+func ExamplePlugin() {
+	scheduler := &fakeScheduler{}
+	p := &Plugin{Now: func() time.Time { return time.Date(2016, 9, 30, 14, 0, 0, 0, time.UTC) }}
+	p.SetBrain(brain.NewMemory())
+	p.SetScheduler(scheduler)
+
+	in := message.Basic{Text: "!remind me in 10m to stretch"}
+	in.Sender = message.User{ID: "U1", Name: "sam", Mention: "<@U1>"}
+	in.Channel = "C1"
+	fmt.Println(p.HandleMessage(in).Text)
+
+	in.Text = "!remind list"
+	fmt.Println(p.HandleMessage(in).Text)
+
+	job := scheduler.jobs[0]
+	p.HandleJob(job, printResponder{job.Channel})
+
+	in.Text = "!remind cancel 1"
+	fmt.Println(p.HandleMessage(in).Text)
+
+	// Times are in the sender's time zone, when the connection knows it
+	in.Sender.TimeZone = "America/New_York"
+	in.Text = "!remind me tomorrow at 9 to call Sam"
+	fmt.Println(p.HandleMessage(in).Text)
+	in.Text = "!remind me every weekday at 9:30 to stand up"
+	p.HandleMessage(in)
+	fmt.Println(scheduler.jobs[len(scheduler.jobs)-1].TimeZone)
+	// Output:
+	// OK, I'll remind you in 10m to stretch. The first reminder is Fri Sep 30 at 2:10pm UTC.
+	// *Your reminders:*
+	// • `1` in 10m to stretch, next Fri Sep 30 at 2:10pm UTC
+	// C1 <@U1> Reminder: stretch
+	// Sorry, you don't have a reminder `1`
+	// OK, I'll remind you tomorrow at 9 to call Sam. The first reminder is Sat Oct 1 at 9:00am EDT.
+	// America/New_York
+}
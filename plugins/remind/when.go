@@ -0,0 +1,197 @@
+package remind
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is when a reminder goes off. Exactly one field is set.
+type schedule struct {
+	At    time.Time
+	Every time.Duration
+	Cron  string
+}
+
+var (
+	// reIn matches "in 10m", "in 1h30m", "in 10 minutes", "in an hour"
+	reIn = regexp.MustCompile(`(?i)^in\s+(.+)$`)
+
+	// reEvery matches "every 2h", "every day at 9", "every friday at 4pm"
+	reEvery = regexp.MustCompile(`(?i)^every\s+(.+)$`)
+
+	// reDay matches "tomorrow at 9", "on friday at 4pm", "today", "at noon"
+	reDay = regexp.MustCompile(`(?i)^(?:(?:on\s+)?(today|tomorrow|` + weekdayPattern + `)\s*)?(?:at\s+(.+))?$`)
+
+	// reAmount matches "10 minutes", "an hour", "2 days"
+	reAmount = regexp.MustCompile(`(?i)^(an?|\d+)\s*(seconds?|secs?|minutes?|mins?|hours?|hrs?|days?|weeks?)$`)
+
+	// reClock matches "9", "9am", "9:30", "9:30pm", "16:30"
+	reClock = regexp.MustCompile(`(?i)^(\d{1,2})(?::(\d{2}))?\s*(am|pm)?$`)
+
+	weekdayPattern = `sunday|monday|tuesday|wednesday|thursday|friday|saturday|sun|mon|tue|tues|wed|thu|thur|thurs|fri|sat`
+
+	// defaultHour is when a reminder for a day without a time goes off
+	defaultHour = 9
+)
+
+// units are the lengths of the units reAmount matches, without a plural s
+var units = map[string]time.Duration{
+	"sec": time.Second, "second": time.Second,
+	"min": time.Minute, "minute": time.Minute,
+	"hr": time.Hour, "hour": time.Hour,
+	"day": 24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// parseWhen parses when a reminder should go off, relative to now. It
+// understands "in 2h", "in 10 minutes", "at 4pm", "tomorrow at 9",
+// "on friday", "every 30m", "every day at 9am", "every weekday at 9:30"
+// and "every friday at 4pm".
+func parseWhen(when string, now time.Time) (s schedule, err error) {
+	when = strings.TrimSpace(strings.ToLower(when))
+
+	if m := reIn.FindStringSubmatch(when); m != nil {
+		d, err := parseAmount(m[1])
+		if err != nil {
+			return s, err
+		}
+		s.At = now.Add(d)
+		return s, nil
+	}
+
+	if m := reEvery.FindStringSubmatch(when); m != nil {
+		return parseEvery(m[1])
+	}
+
+	m := reDay.FindStringSubmatch(when)
+	if m == nil || when == "" {
+		return s, fmt.Errorf("I don't understand when %q is", when)
+	}
+	hour, minute := defaultHour, 0
+	if m[2] != "" {
+		if hour, minute, err = parseClock(m[2]); err != nil {
+			return s, err
+		}
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	switch day := m[1]; {
+	case day == "tomorrow":
+		at = at.AddDate(0, 0, 1)
+	case day == "today":
+	case day != "":
+		weekday, _ := parseWeekday(day)
+		at = at.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 7)
+		}
+	default:
+		// just a time, so it's the next time the clock says that
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+	}
+	if !at.After(now) {
+		return s, errors.New("that time has already passed")
+	}
+	s.At = at
+	return s, nil
+}
+
+// parseEvery parses the part of a recurring reminder after "every"
+func parseEvery(every string) (s schedule, err error) {
+	parts := strings.SplitN(every, " at ", 2)
+	hour, minute := defaultHour, 0
+	if len(parts) == 2 {
+		if hour, minute, err = parseClock(parts[1]); err != nil {
+			return s, err
+		}
+	}
+
+	var days string
+	switch day := strings.TrimSuffix(strings.TrimSpace(parts[0]), "s"); day {
+	case "day":
+		days = "*"
+	case "weekday":
+		days = "1-5"
+	default:
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			if len(parts) == 2 {
+				return s, fmt.Errorf("I don't understand how often %q is", every)
+			}
+			// "every 2h", or "every hour"
+			if s.Every, err = parseAmount(every); err != nil {
+				s.Every, err = parseAmount("1 " + every)
+			}
+			return s, err
+		}
+		days = strconv.Itoa(int(weekday))
+	}
+	s.Cron = fmt.Sprintf("%d %d * * %s", minute, hour, days)
+	return s, nil
+}
+
+// parseAmount parses a length of time, e.g. "10m", "1h30m" or "10 minutes"
+func parseAmount(amount string) (time.Duration, error) {
+	amount = strings.TrimSpace(amount)
+	if d, err := time.ParseDuration(amount); err == nil && d > 0 {
+		return d, nil
+	}
+	m := reAmount.FindStringSubmatch(amount)
+	if m == nil {
+		return 0, fmt.Errorf("I don't understand how long %q is", amount)
+	}
+	n := 1
+	if m[1] != "a" && m[1] != "an" {
+		n, _ = strconv.Atoi(m[1])
+	}
+	unit := strings.TrimSuffix(m[2], "s")
+	if n <= 0 {
+		return 0, errors.New("that time has already passed")
+	}
+	return time.Duration(n) * units[unit], nil
+}
+
+// parseClock parses a time of day, e.g. "9", "9am", "16:30" or "noon"
+func parseClock(clock string) (hour, minute int, err error) {
+	clock = strings.TrimSpace(clock)
+	switch clock {
+	case "noon":
+		return 12, 0, nil
+	case "midnight":
+		return 0, 0, nil
+	}
+	m := reClock.FindStringSubmatch(clock)
+	if m == nil {
+		return 0, 0, fmt.Errorf("I don't understand what time %q is", clock)
+	}
+	hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	if minute > 59 || hour > 23 || (m[3] != "" && (hour < 1 || hour > 12)) {
+		return 0, 0, fmt.Errorf("%q isn't a time of day", clock)
+	}
+	switch {
+	case m[3] == "pm" && hour < 12:
+		hour += 12
+	case m[3] == "am" && hour == 12:
+		hour = 0
+	}
+	return hour, minute, nil
+}
+
+// parseWeekday parses a day name, e.g. "friday" or "fri"
+func parseWeekday(day string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if day == name || (len(day) >= 3 && strings.HasPrefix(name, day)) {
+			return d, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,52 @@
+package remind
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	now := time.Date(2016, 9, 30, 14, 0, 0, 0, time.UTC) // a Friday afternoon
+	at := func(day, hour, minute int) schedule {
+		return schedule{At: time.Date(2016, 9, day, hour, minute, 0, 0, time.UTC)}
+	}
+	tests := []struct {
+		when string
+		want schedule
+	}{
+		{"in 10m", at(30, 14, 10)},
+		{"in 1h30m", at(30, 15, 30)},
+		{"in 10 minutes", at(30, 14, 10)},
+		{"in an hour", at(30, 15, 0)},
+		{"in 2 days", schedule{At: now.AddDate(0, 0, 2)}},
+		{"at 4pm", at(30, 16, 0)},
+		{"at 9", schedule{At: time.Date(2016, 10, 1, 9, 0, 0, 0, time.UTC)}},
+		{"today at 16:30", at(30, 16, 30)},
+		{"tomorrow", schedule{At: time.Date(2016, 10, 1, 9, 0, 0, 0, time.UTC)}},
+		{"Tomorrow at 9:15am", schedule{At: time.Date(2016, 10, 1, 9, 15, 0, 0, time.UTC)}},
+		{"on monday at noon", schedule{At: time.Date(2016, 10, 3, 12, 0, 0, 0, time.UTC)}},
+		{"friday", schedule{At: time.Date(2016, 10, 7, 9, 0, 0, 0, time.UTC)}},
+		{"every 30m", schedule{Every: 30 * time.Minute}},
+		{"every hour", schedule{Every: time.Hour}},
+		{"every day", schedule{Cron: "0 9 * * *"}},
+		{"every weekday at 9:30", schedule{Cron: "30 9 * * 1-5"}},
+		{"every Friday at 4pm", schedule{Cron: "0 16 * * 5"}},
+		{"every mondays", schedule{Cron: "0 9 * * 1"}},
+	}
+	for _, tt := range tests {
+		got, err := parseWhen(tt.when, now)
+		if err != nil {
+			t.Errorf("%q: %s", tt.when, err)
+			continue
+		}
+		if !got.At.Equal(tt.want.At) || got.Every != tt.want.Every || got.Cron != tt.want.Cron {
+			t.Errorf("%q: got %+v, want %+v", tt.when, got, tt.want)
+		}
+	}
+
+	for _, when := range []string{"", "later", "today at 9", "at 13pm", "in 0m", "every blue moon", "every day at teatime"} {
+		if got, err := parseWhen(when, now); err == nil {
+			t.Errorf("%q: got %+v, want an error", when, got)
+		}
+	}
+}
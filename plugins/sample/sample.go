@@ -5,13 +5,22 @@ package sample
 import (
 	"regexp"
 
+	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/log"
 	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
 )
 
 // Plugin initializes the interface
 type Plugin struct{}
 
+func init() {
+	plugins.Register("sample", func(settings config.Section) (plugins.Plugin, error) {
+		p := &Plugin{}
+		return p, settings.Decode(p)
+	})
+}
+
 var rePluginRegexp = regexp.MustCompile(`(?i)^!sample`)
 
 // Usage prints detailed usage instructions
@@ -0,0 +1,73 @@
+package plugins
+
+import "time"
+
+// Job is a message the bot posts later, or on a schedule. Exactly one of
+// Cron, Every and At must be set.
+type Job struct {
+	// ID identifies the job among the plugin's jobs. If it's empty when the
+	// job is scheduled one is generated. Scheduling a job with the ID of one
+	// of the plugin's existing jobs replaces it.
+	ID string
+
+	// Connection is the name of the connection to post on. If there's no
+	// connection with that name, the first one that can post is used.
+	Connection string
+
+	// Channel is where to post
+	Channel string
+
+	// Text is posted when the job runs, unless the plugin is a JobPlugin
+	Text string
+
+	// Cron runs the job on a standard five field cron schedule in TimeZone,
+	// e.g. "0 9 * * 1-5" for 9am every weekday
+	Cron string
+
+	// TimeZone is the IANA name of the time zone Cron is in, e.g.
+	// "America/New_York". Empty means the bot's local time.
+	TimeZone string
+
+	// Every runs the job repeatedly, this long apart
+	Every time.Duration
+
+	// At runs the job once, at this time
+	At time.Time
+
+	// Next is when the job will next run. It's set by the scheduler.
+	Next time.Time
+}
+
+// Scheduler schedules a plugin's jobs. Jobs are kept in the bot's brain, so
+// they survive restarts if the brain is on disk. A job that should have run
+// while the bot was stopped runs once as soon as the bot starts again.
+type Scheduler interface {
+	// Schedule adds the job, or replaces the plugin's job with the same ID,
+	// and returns it with its ID and Next time set
+	Schedule(Job) (Job, error)
+
+	// Cancel removes the plugin's job with the ID
+	Cancel(id string) error
+
+	// Jobs returns the plugin's jobs, ordered by when they next run
+	Jobs() []Job
+}
+
+// SchedulerPlugin is a Plugin that schedules jobs. SetScheduler is called
+// before every OnInit.
+type SchedulerPlugin interface {
+	Plugin
+
+	// SetScheduler gives the plugin its scheduler
+	SetScheduler(Scheduler)
+}
+
+// JobPlugin is a SchedulerPlugin that decides what to post when each of its
+// jobs runs, rather than posting the job's Text
+type JobPlugin interface {
+	SchedulerPlugin
+
+	// HandleJob sends whatever should be posted to the job's channel
+	// through the Responder
+	HandleJob(Job, Responder)
+}
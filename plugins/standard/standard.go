@@ -0,0 +1,35 @@
+// Package standard registers the pre-installed plugins. Import it for its
+// side effects to make them available to the configuration file:
+//
+//	import _ "github.com/handwritingio/deckard-bot/plugins/standard"
+//
+// It lives outside the plugins package so that plugins themselves can import
+// package plugins.
+package standard
+
+import (
+	"github.com/handwritingio/deckard-bot/config"
+	"github.com/handwritingio/deckard-bot/plugins"
+
+	// Each plugin registers itself when it's imported
+	_ "github.com/handwritingio/deckard-bot/plugins/cats"
+	_ "github.com/handwritingio/deckard-bot/plugins/dice"
+	_ "github.com/handwritingio/deckard-bot/plugins/principles"
+	_ "github.com/handwritingio/deckard-bot/plugins/remind"
+	_ "github.com/handwritingio/deckard-bot/plugins/tableflip"
+	_ "github.com/handwritingio/deckard-bot/plugins/write"
+)
+
+// Plugins returns all the pre-installed standard plugins that require no initialization
+func Plugins() []plugins.Plugin {
+	var ps []plugins.Plugin
+	for _, name := range config.DefaultPlugins {
+		p, err := plugins.New(name, nil)
+		if err != nil {
+			// Every default plugin is imported above
+			panic(err)
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
@@ -6,39 +6,55 @@ package tableflip
 import (
 	"regexp"
 
+	"github.com/handwritingio/deckard-bot/config"
 	"github.com/handwritingio/deckard-bot/message"
+	"github.com/handwritingio/deckard-bot/plugins"
 )
 
 // Plugin ...
 type Plugin struct{}
 
-var (
-	// reTableFlip is the regexp variables for logic in HandleMessage
-	reTableFlipChill = regexp.MustCompile(`(?i)^!table(flip|chill)$`)
+func init() {
+	plugins.Register("tableflip", func(settings config.Section) (plugins.Plugin, error) {
+		p := &Plugin{}
+		return p, settings.Decode(p)
+	})
+}
+
+var commands = plugins.NewRouter(
+	&plugins.Command{
+		Name:        "!tableflip",
+		Description: "to get some table flipping action!",
+		Handler:     reply("(╯°□°）╯︵ ┻━┻"),
+	},
+	&plugins.Command{
+		Name:        "!tablechill",
+		Description: "to calm things down",
+		Handler:     reply("┬─┬ノ( º _ ºノ)"),
+	},
 )
 
+// reply returns a command handler that always replies with text
+func reply(text string) func(message.Basic, plugins.Args) message.Basic {
+	return func(in message.Basic, args plugins.Args) message.Basic {
+		return message.Basic{Text: text}
+	}
+}
+
 // Usage prints detailed usage instructions for the plugin
 func (p Plugin) Usage() string {
-	return "`!tableflip` to get some table flipping action!\n" +
-		"`!tablechill` to calm things down"
+	return commands.Usage()
 }
 
 // HandleMessage is responsible for handling the incoming message
 // and returning a response based on the message provides
 func (p Plugin) HandleMessage(in message.Basic) (out message.Basic) {
-	flipOrChill := reTableFlipChill.FindStringSubmatch(in.Text)[1]
-	if flipOrChill == "flip" {
-		out.Text = "(╯°□°）╯︵ ┻━┻"
-	}
-	if flipOrChill == "chill" {
-		out.Text = "┬─┬ノ( º _ ºノ)"
-	}
-	return
+	return commands.HandleMessage(in)
 }
 
 // Command returns a list of commands the plugin provides
 func (p Plugin) Command() []string {
-	return []string{"!tableflip", "!tablechill"}
+	return commands.Command()
 }
 
 // OnInit returns an error if the plugin could not be started
@@ -53,5 +69,5 @@ func (p Plugin) Name() string {
 
 // Regexp returns the regexp of a message that should be handled by this plugin
 func (p Plugin) Regexp() *regexp.Regexp {
-	return reTableFlipChill
+	return commands.Regexp()
 }
@@ -0,0 +1,125 @@
+// Package ratelimit provides token bucket rate limits, keyed by anything
+// that can be written as a string such as a user or channel
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Burst events at once, refilling at Burst events every Per.
+// The zero Limit is unlimited.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseLimit parses a limit written as "burst/period", e.g. "10/1m" for
+// ten a minute. An empty string is unlimited.
+func ParseLimit(s string) (l Limit, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return l, fmt.Errorf("limit %q should look like burst/period, e.g. 10/1m", s)
+	}
+	if l.Burst, err = strconv.Atoi(parts[0]); err != nil || l.Burst < 1 {
+		return Limit{}, fmt.Errorf("limit %q has an invalid burst", s)
+	}
+	if l.Per, err = time.ParseDuration(parts[1]); err != nil || l.Per <= 0 {
+		return Limit{}, fmt.Errorf("limit %q has an invalid period", s)
+	}
+	return l, nil
+}
+
+// Unlimited reports whether the limit allows everything
+func (l Limit) Unlimited() bool {
+	return l.Burst <= 0 || l.Per <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// Limiter keeps a token bucket for each key. It's safe for concurrent use.
+type Limiter struct {
+	Limit Limit
+
+	// Now returns the current time, and can be replaced in tests
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter that applies the limit to every key
+func NewLimiter(l Limit) *Limiter {
+	return &Limiter{
+		Limit:   l,
+		Now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the key's bucket. If the bucket is empty it
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.Limit.Unlimited() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.Now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate()
+	if b.tokens > float64(l.Limit.Burst) {
+		b.tokens = float64(l.Limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate() * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rate is how many tokens are added to a bucket each second
+func (l *Limiter) rate() float64 {
+	return float64(l.Limit.Burst) / l.Limit.Per.Seconds()
+}
+
+// sweep forgets buckets that have been idle long enough to be full again,
+// at most once every Per. l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Limit.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.Limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+func ExampleParseLimit() {
+	for _, s := range []string{"10/1m", "", "ten/1m"} {
+		l, err := ParseLimit(s)
+		fmt.Println(l, err)
+	}
+	// Output:
+	// 10/1m0s <nil>
+	// unlimited <nil>
+	// unlimited limit "ten/1m" has an invalid burst
+}
+
+func ExampleLimiter() {
+	now := time.Date(2016, 10, 1, 9, 0, 0, 0, time.UTC)
+	l := NewLimiter(Limit{Burst: 2, Per: time.Minute})
+	l.Now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		fmt.Println(l.Allow("alice"))
+	}
+	fmt.Println(l.Allow("bob"))
+
+	now = now.Add(30 * time.Second)
+	fmt.Println(l.Allow("alice"))
+	// Output:
+	// true 0s
+	// true 0s
+	// false 30s
+	// true 0s
+	// true 0s
+}